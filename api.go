@@ -9,6 +9,7 @@ type stateOperation struct {
 	kt     *KeyType
 	imm    []byte
 	mut    *MutState
+	ver    int // see Versioner
 	action int
 	err    chan error
 }
@@ -16,6 +17,9 @@ type stateOperation struct {
 // When called, all checkpointing is shut down,
 // and a final, full checkpoint is written to disk.
 func (db *StateDB) FinalCommit() error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 
 	// force a zero checkpoint and wait
 	// till the checkpoint has been fully committed
@@ -27,16 +31,29 @@ func (db *StateDB) FinalCommit() error {
 	// send an error chan on which to respond in the
 	// case of shut down issues
 	errChan := make(chan error)
-	db.quit <- errChan
+	select {
+	case db.quit <- errChan:
+	case <-db.stopped:
+		return ClosedError
+	}
 
 	return <-errChan
 }
 
 func (db *StateDB) Quit() error {
+	// nothing runs in a read-only database
+	if db.readOnly {
+		return nil
+	}
 	errChan := make(chan error)
 
-	db.quit <- errChan
-	return <-errChan
+	select {
+	case db.quit <- errChan:
+		return <-errChan
+	case <-db.stopped:
+		// already shut down
+		return nil
+	}
 }
 
 func (db *StateDB) forceZeroCPTBlock() error {
@@ -54,7 +71,9 @@ func (db *StateDB) forceZeroCPTBlock() error {
 		t:        c,
 		waitChan: commitBlock,
 	}
-	db.sync_chan <- m
+	if err := db.sendSync(m); err != nil {
+		return err
+	}
 
 	if err := <-errChan; err != nil {
 		if err == ActiveCommitError {
@@ -62,7 +81,9 @@ func (db *StateDB) forceZeroCPTBlock() error {
 			// was being checkpointed, the commitBlock
 			// will be signalled once *any* commit returns
 			<-commitBlock
-			db.sync_chan <- m
+			if err := db.sendSync(m); err != nil {
+				return err
+			}
 			err = <-errChan
 			if err != nil {
 				return err
@@ -80,45 +101,62 @@ func (db *StateDB) forceZeroCPTBlock() error {
 }
 
 func (db *StateDB) ForceFullCPT() error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	errChan := make(chan error)
 	c := timeline.Tick()
 	c.SyncStart()
-	db.sync_chan <- &msg{
+	if err := db.sendSync(&msg{
 		time:     time.Now(),
 		err:      errChan,
 		forceCPT: true,    // don't query schedular
 		cptType:  ZEROCPT, // force a zero checkpoint
 		t:        c,
+	}); err != nil {
+		return err
 	}
+	err := <-errChan
 	c.SyncEnd()
-	return <-errChan
+	return err
 }
 
 func (db *StateDB) ForceDeltaCPT() error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	err := make(chan error)
 	c := timeline.Tick()
 	c.SyncStart()
-	db.sync_chan <- &msg{
+	if e := db.sendSync(&msg{
 		time:     time.Now(),
 		err:      err,
 		forceCPT: true,
 		cptType:  DELTACPT,
 		t:        c,
+	}); e != nil {
+		return e
 	}
+	e := <-err
 	c.SyncEnd()
-	return <-err
+	return e
 }
 
 func (db *StateDB) ForceCheckpoint() error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	err := make(chan error)
 	c := timeline.Tick()
 	c.SyncStart()
-	db.sync_chan <- &msg{
+	if e := db.sendSync(&msg{
 		time:     time.Now(),
 		err:      err,
 		forceCPT: true,
 		cptType:  NONDETERMCPT,
 		t:        c,
+	}); e != nil {
+		return e
 	}
 	e := <-err
 	c.SyncEnd()
@@ -128,19 +166,71 @@ func (db *StateDB) ForceCheckpoint() error {
 	return e
 }
 
+// ForceCheckpointWithin forces the checkpoint that is expected to be
+// written within the deadline, based on the write estimates of Stat, and
+// waits for it to be written. The type NONDETERMCPT would choose is
+// preferred, otherwise the cheaper delta checkpoint. It returns
+// DeadlineError, without checkpointing, if neither is expected to fit.
+func (db *StateDB) ForceCheckpointWithin(deadline time.Duration) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
+	if deadline <= 0 {
+		return DeadlineError
+	}
+	errChan := make(chan error)
+	commitBlock := make(chan error, 1)
+
+	c := timeline.Tick()
+	c.SyncStart()
+	m := &msg{
+		time:     time.Now(),
+		err:      errChan,
+		forceCPT: true,
+		cptType:  NONDETERMCPT,
+		t:        c,
+		waitChan: commitBlock,
+		deadline: time.Now().Add(deadline),
+		strict:   true,
+	}
+	if err := db.sendSync(m); err != nil {
+		return err
+	}
+	err := <-errChan
+	if err == ActiveCommitError {
+		// the active commit eats into the window,
+		// which is budgeted again once it returns
+		<-commitBlock
+		if err := db.sendSync(m); err != nil {
+			return err
+		}
+		err = <-errChan
+	}
+	c.SyncEnd()
+	if err != nil {
+		return err
+	}
+	return <-commitBlock
+}
+
 // Sync is a call for consistency; if the monitor has signalled a checkpoint
 // a checkpoint will be committed. During this time, we cannot allow any processes to
 // write or delete objects in the database.
 func (db *StateDB) PointOfConsistency() error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 	// response channel
 	err := make(chan error)
 	c := timeline.Tick()
 	c.SyncStart()
-	db.sync_chan <- &msg{
+	if e := db.sendSync(&msg{
 		time:    time.Now(),
 		err:     err,
 		t:       c,
 		cptType: NONDETERMCPT,
+	}); e != nil {
+		return e
 	}
 	e := <-err
 	c.SyncEnd()
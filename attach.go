@@ -0,0 +1,84 @@
+package statedb
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Binding pairs a restored KeyType with the live pointer
+// to its mutable state
+type Binding struct {
+	KT  *KeyType
+	Mut interface{}
+}
+
+// Attach binds mut to the restored mutable state of kt, without
+// decoding the immutable state. It is meant for applications that rebuild
+// their objects without RestoreIter or RestoreSingle.
+//
+// mut must be a pointer to a value the checkpointed mutable state can be
+// decoded into. If copyValue is set, the checkpointed value is decoded
+// into mut, otherwise mut is left as is.
+func (db *StateDB) Attach(kt *KeyType, mut interface{}, copyValue bool) error {
+	return db.AttachAll([]Binding{{KT: kt, Mut: mut}}, copyValue)
+}
+
+// AttachAll binds every pointer to the restored mutable state of its
+// KeyType. Nothing is bound unless every binding is valid.
+func (db *StateDB) AttachAll(bindings []Binding, copyValue bool) error {
+
+	if db == nil {
+		return fmt.Errorf("StateDB: database has not been initialized. Call NewStateDB(...)")
+	}
+
+	var err error
+	db.serve(func() {
+		err = db.attach(bindings, copyValue)
+	})
+	return err
+}
+
+// called from stateLoop
+func (db *StateDB) attach(bindings []Binding, copyValue bool) error {
+
+	states := make([]*MutState, len(bindings))
+	values := make([]reflect.Value, len(bindings))
+
+	for i, b := range bindings {
+		if b.KT == nil || !b.KT.IsValid() {
+			return fmt.Errorf("StateDB.Attach: invalid keytype %v", b.KT)
+		}
+		mutv := reflect.ValueOf(b.Mut)
+		if err := validateMutableEntry(mutv); err != nil {
+			return fmt.Errorf("StateDB.Attach: %s: %s", b.KT.String(), err.Error())
+		}
+
+		db.RLock()
+		ms := db.mutable.lookup(b.KT)
+		db.RUnlock()
+		if ms == nil {
+			return fmt.Errorf("StateDB.Attach: %s has no mutable state", b.KT.String())
+		}
+
+		// a state registered in this run has no checkpointed value,
+		// and is already bound to the pointer it was registered with
+		if ms.Val != nil {
+			scratch := reflect.New(mutv.Elem().Type())
+			if err := Decode(ms.Val, scratch.Interface()); err != nil {
+				return fmt.Errorf("StateDB.Attach: %s: incompatible type %s: %s",
+					b.KT.String(), mutv.Type().String(), err.Error())
+			}
+		}
+		states[i], values[i] = ms, mutv
+	}
+
+	for i, ms := range states {
+		if copyValue && ms.Val != nil {
+			if err := Decode(ms.Val, values[i].Interface()); err != nil {
+				return err
+			}
+		}
+		db.bind(ms, values[i])
+	}
+	return nil
+}
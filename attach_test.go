@@ -0,0 +1,85 @@
+package statedb
+
+import (
+	"testing"
+)
+
+type unrelated struct {
+	Other string
+}
+
+func TestAttach(t *testing.T) {
+	db, _ := reopenUnrestored(t, nil)
+	defer db.Quit()
+
+	kt1, _ := NewIntKeyType(1, ReflectTypeM(&ent{}))
+	kt2, _ := NewIntKeyType(2, ReflectTypeM(&ent{}))
+	missing, _ := NewIntKeyType(3, ReflectTypeM(&ent{}))
+
+	m1, m2 := &entMut{}, &entMut{Pos: 99}
+	if err := db.Attach(kt1, *m1, false); err == nil {
+		t.Fatal("attached a non-pointer")
+	}
+	if err := db.Attach(kt1, &unrelated{}, false); err == nil {
+		t.Fatal("attached an incompatible type")
+	}
+	if err := db.Attach(missing, m1, false); err == nil {
+		t.Fatal("attached a state that does not exist")
+	}
+
+	// nothing is bound if one of the bindings is invalid
+	err := db.AttachAll([]Binding{{kt1, m1}, {missing, m2}}, true)
+	if err == nil {
+		t.Fatal("attached a state that does not exist")
+	}
+	if len(db.Unrestored()) != 2 || m1.Pos != 0 {
+		t.Fatal("a failed AttachAll bound a state")
+	}
+
+	if err := db.AttachAll([]Binding{{kt1, m1}, {kt2, m2}}, true); err != nil {
+		t.Fatal(err)
+	}
+	if m1.Pos != 10 || m2.Pos != 20 {
+		t.Fatalf("checkpointed values were not copied: %v %v", m1, m2)
+	}
+	if !db.Ready() {
+		t.Fatal("not ready after every state was attached")
+	}
+
+	// the attached pointers are checkpointed from now on
+	m1.Pos, m2.Pos = 11, 21
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := restore(db.fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := Restore[ent](restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for it.Next() {
+		if e := it.Value(); e.m.Pos != e.ID*10+1 {
+			t.Errorf("attached pointer of %d was not checkpointed: %v", e.ID, e.m)
+		}
+	}
+}
+
+func TestAttachWithoutCopy(t *testing.T) {
+	db, _ := reopenUnrestored(t, nil)
+	defer db.Quit()
+
+	kt1, _ := NewIntKeyType(1, ReflectTypeM(&ent{}))
+	m1 := &entMut{Pos: 5}
+	if err := db.Attach(kt1, m1, false); err != nil {
+		t.Fatal(err)
+	}
+	if m1.Pos != 5 {
+		t.Fatal("pointer was overwritten without copyValue")
+	}
+	kts := db.Unrestored()
+	if len(kts) != 1 || kts[0].IntID() != 2 {
+		t.Fatalf("unexpected unrestored states: %v", kts)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	// "log"
 	// "os"
+	"time"
 )
 
 var (
-	NoDataError = errors.New("No Data to checkpoint")
+	NoDataError   = errors.New("No Data to checkpoint")
+	DeadlineError = errors.New("No checkpoint is expected to be written before the deadline")
 )
 
 func (db *StateDB) encodeCheckpoint(cptType int, stat *Stat) (*CommitReq, error) {
@@ -30,29 +32,62 @@ func (db *StateDB) encodeCheckpoint(cptType int, stat *Stat) (*CommitReq, error)
 		}
 		return db.encodeDeltaCheckpoint()
 	case NONDETERMCPT:
-		// base case: first checkpoint call
-		if db.ctx.RCID == 0 {
-			return db.encodeZeroCheckpoint()
-		}
-		// * a zero checkpoint exists *
-		// if nothing is in the delta
-		// - we obviously commit a delta checkpoint
-		if len(db.delta) == 0 {
-			return db.encodeDeltaCheckpoint()
-		}
-
-		// use the stat to determine which checkpoint to choose
-		if stat.expReadDelta() < stat.expReadZero() {
+		if db.nondetermCheckpoint(stat) == DELTACPT {
 			return db.encodeDeltaCheckpoint()
 		}
-
-		// TODO: maybe provide this with seperate heuristic
 		return db.encodeZeroCheckpoint()
 	}
 
 	return nil, fmt.Errorf("InvalidCheckpointType: %d", cptType)
 }
 
+// Returns the type of checkpoint NONDETERMCPT encodes
+func (db *StateDB) nondetermCheckpoint(stat *Stat) int {
+	// base case: first checkpoint call
+	if db.ctx.RCID == 0 {
+		return ZEROCPT
+	}
+	// * a zero checkpoint exists *
+	// if nothing is in the delta
+	// - we obviously commit a delta checkpoint
+	if len(db.delta) == 0 {
+		return DELTACPT
+	}
+
+	// use the stat to determine which checkpoint to choose
+	if stat.expReadDelta() < stat.expReadZero() {
+		return DELTACPT
+	}
+
+	// TODO: maybe provide this with seperate heuristic
+	return ZEROCPT
+}
+
+// Returns the type of checkpoint to encode within the window, and whether
+// it is expected to be written within it. Unless cheapest is set, the type
+// NONDETERMCPT would choose is preferred if it fits, otherwise the cheaper
+// delta checkpoint. Without an estimate, before the first zero checkpoint
+// was committed, a checkpoint is expected to fit. The retry of a commit
+// that failed to read the lease is always a zero checkpoint.
+func (db *StateDB) budgetCheckpoint(stat *Stat, window time.Duration, cheapest bool) (int, bool) {
+	fits := func(cptType int) bool {
+		exp := stat.expWrite(cptType)
+		return exp < 0 || exp <= window
+	}
+	// a delta checkpoint needs a reference checkpoint, and
+	// the delta lost by the failed commit a new one
+	if db.ctx.RCID == 0 || db.retry {
+		return ZEROCPT, fits(ZEROCPT)
+	}
+	if cheapest {
+		return DELTACPT, fits(DELTACPT)
+	}
+	if cptType := db.nondetermCheckpoint(stat); fits(cptType) {
+		return cptType, true
+	}
+	return DELTACPT, fits(DELTACPT)
+}
+
 // Encodes the two databases delta and mutable
 // and passes the encoded data on to be committed
 // - returns immediately after encoding, and handles any commit errors
@@ -77,7 +112,7 @@ func (db *StateDB) encodeDeltaCheckpoint() (*CommitReq, error) {
 			return nil, err
 		}
 	}
-	r.mut, err = encodeMutable(db.mutable, db.ctx.MCNT)
+	r.mut, err = encodeMutable(db.mutable, r.ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -94,18 +129,28 @@ func (db *StateDB) encodeZeroCheckpoint() (*CommitReq, error) {
 		return nil, NoDataError
 	}
 
+	// every entry deferred by a lazy restore is needed
+	if err := db.materializeAll(); err != nil {
+		return nil, err
+	}
+
 	r := &CommitReq{
 		cpt_type: ZEROCPT,
 		ctx:      db.ctx.newZeroContext(),
 	}
+	r.ctx.Dedup = db.opts.dedup()
 
 	var err error
-	r.imm, err = encodeImmutable(db.immutable)
+	if r.ctx.Dedup {
+		r.imm, r.chunks, r.refs, err = encodeManifest(db.immutable, db.chunks, db.opts.chunkSize())
+	} else {
+		r.imm, err = encodeImmutable(db.immutable)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	r.mut, err = encodeMutable(db.mutable, db.ctx.MCNT)
+	r.mut, err = encodeMutable(db.mutable, r.ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,81 @@
+package statedb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetCheckpoint(t *testing.T) {
+	db := &StateDB{
+		ctx:   &Context{},
+		delta: DeltaTypeMap{"ent": DeltaStateOpMap{}},
+	}
+	// a zero checkpoint takes 11s, a delta checkpoint 1s
+	stat := &Stat{t_i: 10 * time.Second, t_m: time.Second, t_d: time.Second}
+
+	if cptType, fits := db.budgetCheckpoint(stat, time.Second, false); cptType != ZEROCPT || fits {
+		t.Fatalf("expected a zero checkpoint that does not fit, got %d %v", cptType, fits)
+	}
+
+	db.ctx.RCID = 1
+	for _, c := range []struct {
+		window  time.Duration
+		cptType int
+		fits    bool
+	}{
+		{time.Minute, ZEROCPT, true},
+		{5 * time.Second, DELTACPT, true},
+		{time.Millisecond, DELTACPT, false},
+	} {
+		cptType, fits := db.budgetCheckpoint(stat, c.window, false)
+		if cptType != c.cptType || fits != c.fits {
+			t.Fatalf("window %s: expected %d %v, got %d %v", c.window, c.cptType, c.fits, cptType, fits)
+		}
+	}
+
+	// the cheapest checkpoint is a delta checkpoint, even if the zero fits
+	if cptType, fits := db.budgetCheckpoint(stat, time.Minute, true); cptType != DELTACPT || !fits {
+		t.Fatalf("expected the cheapest delta checkpoint, got %d %v", cptType, fits)
+	}
+
+	// the retry of a failed commit is budgeted as a zero checkpoint
+	db.retry = true
+	if cptType, fits := db.budgetCheckpoint(stat, 5*time.Second, true); cptType != ZEROCPT || fits {
+		t.Fatalf("expected a retried zero checkpoint that does not fit, got %d %v", cptType, fits)
+	}
+	db.retry = false
+
+	// without an estimate, any checkpoint fits
+	if _, fits := db.budgetCheckpoint(&Stat{}, time.Nanosecond, false); !fits {
+		t.Fatal("expected the checkpoint to fit without an estimate")
+	}
+}
+
+func TestForceCheckpointWithin(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	defer db.Quit()
+	registerEnts(t, db, &ent{ID: 1})
+
+	if err := db.ForceCheckpointWithin(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ctx, _ := retrieveContext(fs); ctx.ID() != "1.1" {
+		t.Fatalf("expected the zero checkpoint 1.1, got %s", ctx.ID())
+	}
+
+	registerEnts(t, db, &ent{ID: 2})
+	if err := db.ForceCheckpointWithin(time.Nanosecond); err != DeadlineError {
+		t.Fatalf("expected DeadlineError, got %v", err)
+	}
+	if ctx, _ := retrieveContext(fs); ctx.ID() != "1.1" {
+		t.Fatalf("a checkpoint was written past the deadline: %s", ctx.ID())
+	}
+	if err := db.ForceCheckpointWithin(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// the zero checkpoint NONDETERMCPT chooses fits
+	if ctx, _ := retrieveContext(fs); ctx.ID() != "2.1" {
+		t.Fatalf("expected the zero checkpoint 2.1, got %s", ctx.ID())
+	}
+}
@@ -0,0 +1,145 @@
+package statedb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Close without a final checkpoint
+const NOCPT = -1
+
+var (
+	ClosedError = errors.New("The database has been closed")
+)
+
+// Close shuts the database down gracefully. It refuses any further sync
+// with ClosedError and waits for the active commit to be written. Then it
+// takes a final checkpoint of type final (ZEROCPT, DELTACPT, NONDETERMCPT,
+// or NOCPT for none) and waits for it to be written. Finally it stops the
+// model, the monitor and the committer. It returns every error it ran
+// into, and ctx.Err() if ctx ends first. Closing an already closed
+// database returns the result of the first Close. Once it has shut
+// down, Register and Unregister return ClosedError as well.
+func (db *StateDB) Close(ctx context.Context, final int) error {
+	// nothing runs in a read-only database
+	if db.readOnly {
+		return nil
+	}
+	db.closer.Do(func() {
+		db.closeErr = db.close(ctx, final, false)
+	})
+	return db.closeErr
+}
+
+// If budget is set, the type of the final checkpoint is the cheapest one,
+// see budgetCheckpoint, with the deadline of ctx as the window
+func (db *StateDB) close(ctx context.Context, final int, budget bool) error {
+	var errs []error
+
+	drained := make(chan bool)
+	select {
+	case db.drain_chan <- drained:
+	case <-db.stopped:
+		// shut down by Quit or FinalCommit
+		return nil
+	}
+
+	select {
+	case <-drained:
+		if final != NOCPT {
+			if err := db.finalCheckpoint(ctx, final, budget); err != nil {
+				errs = append(errs, fmt.Errorf("StateDB.Close: final checkpoint: %w", err))
+			}
+		}
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("StateDB.Close: the active commit did not return: %w", ctx.Err()))
+	}
+
+	// buffered, in case ctx ends first
+	errChan := make(chan error, 1)
+	select {
+	case db.quit <- errChan:
+	case <-db.stopped:
+		return errors.Join(errs...)
+	}
+	select {
+	case err := <-errChan:
+		if err != nil {
+			errs = append(errs, err)
+		}
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("StateDB.Close: the shut down did not complete: %w", ctx.Err()))
+	}
+	return errors.Join(errs...)
+}
+
+// Takes the final checkpoint and waits for it to be written
+func (db *StateDB) finalCheckpoint(ctx context.Context, cptType int, budget bool) error {
+	// buffered, so the stateLoop never waits for an abandoned Close
+	errChan := make(chan error, 1)
+	waitChan := make(chan error, 1)
+
+	c := timeline.Tick()
+	c.SyncStart()
+	m := &msg{
+		time:     time.Now(),
+		err:      errChan,
+		forceCPT: true,
+		cptType:  cptType,
+		t:        c,
+		waitChan: waitChan,
+		final:    true,
+	}
+	if deadline, ok := ctx.Deadline(); ok && budget {
+		m.deadline = deadline
+		m.cheapest = true
+	}
+	select {
+	case db.sync_chan <- m:
+	case <-db.stopped:
+		return ClosedError
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-errChan:
+		c.SyncEnd()
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-waitChan:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sends the sync to the stateLoop, or returns
+// ClosedError once it has been shut down
+func (db *StateDB) sendSync(m *msg) error {
+	select {
+	case db.sync_chan <- m:
+		return nil
+	case <-db.stopped:
+		return ClosedError
+	}
+}
+
+// Sends the operation to the stateLoop, or returns
+// ClosedError once it has been shut down
+func (db *StateDB) sendOp(so *stateOperation) error {
+	select {
+	case db.op_chan <- so:
+		return nil
+	case <-db.stopped:
+		return ClosedError
+	}
+}
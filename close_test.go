@@ -0,0 +1,135 @@
+package statedb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Blocks the commit of the reference checkpoint until the returned
+// function is called
+func gateCommit(fs *memFS, name string) func() {
+	gate := make(chan bool)
+	fs.Lock()
+	fs.onPut = func(put string) {
+		if put == name {
+			<-gate
+		}
+	}
+	fs.Unlock()
+	return func() {
+		close(gate)
+	}
+}
+
+func TestClose(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db, &ent{ID: 1})
+
+	if err := db.Close(context.Background(), ZEROCPT); err != nil {
+		t.Fatal(err)
+	}
+	if ctx, err := retrieveContext(fs); err != nil || ctx.RCID != 1 {
+		t.Fatalf("the final checkpoint was not written: %v %v", ctx, err)
+	}
+
+	// closing is idempotent, and nothing syncs afterwards
+	if err := db.Close(context.Background(), ZEROCPT); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PointOfConsistency(); err != ClosedError {
+		t.Fatalf("expected ClosedError, got %v", err)
+	}
+}
+
+func TestRegisterAfterClose(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	e := &ent{ID: 1}
+	registerEnts(t, db, e)
+	if err := db.Close(context.Background(), NOCPT); err != nil {
+		t.Fatal(err)
+	}
+
+	kt, _ := ReflectKeyTypeM(e)
+	res := make(chan error, 2)
+	go func() {
+		_, err := db.Register(&ent{ID: 2})
+		res <- err
+		res <- db.Unregister(kt)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-res:
+			if err != ClosedError {
+				t.Fatalf("expected ClosedError, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the operation blocked after Close")
+		}
+	}
+}
+
+func TestCloseDrainsCommit(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db, &ent{ID: 1})
+
+	release := gateCommit(fs, "1/imm.cpt")
+	if err := db.ForceFullCPT(); err != nil {
+		t.Fatal(err)
+	}
+	res := make(chan error)
+	go func() {
+		res <- db.Close(context.Background(), DELTACPT)
+	}()
+
+	// syncs are refused while the commit drains
+	for {
+		if err := db.PointOfConsistency(); err == ClosedError {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-res:
+		t.Fatalf("Close returned before the active commit: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	release()
+	if err := <-res; err != nil {
+		t.Fatal(err)
+	}
+	if ctx, _ := retrieveContext(fs); ctx.ID() != "1.2" {
+		t.Fatalf("expected the final delta checkpoint 1.2, got %s", ctx.ID())
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db, &ent{ID: 1})
+
+	release := gateCommit(fs, "1/imm.cpt")
+	if err := db.ForceFullCPT(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.Close(ctx, ZEROCPT); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	// the shut down completes once the commit returns
+	release()
+	<-db.stopped
+	if c, _ := retrieveContext(fs); c.RCID != 1 {
+		t.Fatalf("the active commit was not written: %#v", c)
+	}
+}
@@ -0,0 +1,63 @@
+// Package cli holds what the statedb commands have in common
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/paddie/statedb"
+	"github.com/paddie/statedb/fs"
+	"launchpad.net/goamz/aws"
+)
+
+// Location of a checkpoint, set through the command line
+type FSFlags struct {
+	dir    *string
+	bucket *string
+	region *string
+	prefix *string
+}
+
+// Registers the flags that locate a checkpoint on the
+// default FlagSet, either a directory or an S3 bucket
+func NewFSFlags() *FSFlags {
+	return &FSFlags{
+		dir:    flag.String("dir", "", "checkpoint directory"),
+		bucket: flag.String("bucket", "", "S3 bucket holding the checkpoint, authenticated from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"),
+		region: flag.String("region", "eu-west-1", "region of the S3 bucket"),
+		prefix: flag.String("prefix", "", "directory of the checkpoint in the S3 bucket"),
+	}
+}
+
+// Open returns the Persistence of the checkpoint
+func (f *FSFlags) Open() (statedb.Persistence, error) {
+
+	if (*f.dir == "") == (*f.bucket == "") {
+		return nil, errors.New("exactly one of -dir and -bucket must be set")
+	}
+
+	if *f.dir != "" {
+		if _, err := os.Stat(*f.dir); err != nil {
+			return nil, err
+		}
+		return fs.NewFS_OS(*f.dir)
+	}
+
+	region, ok := aws.Regions[*f.region]
+	if !ok {
+		return nil, fmt.Errorf("unknown region '%s'", *f.region)
+	}
+	auth, err := aws.EnvAuth()
+	if err != nil {
+		return nil, err
+	}
+	return fs.NewFS_S3(auth, region, *f.prefix, *f.bucket)
+}
+
+// Fatal prints the error and exits
+func Fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
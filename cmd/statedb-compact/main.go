@@ -0,0 +1,38 @@
+// Command statedb-compact replays the delta checkpoints of the most
+// recent checkpoint into a new reference checkpoint:
+//
+//	statedb-compact -dir /tmp/cpt
+//	statedb-compact -bucket mybucket -prefix sim
+//
+// The job must be stopped, or must not checkpoint to the same location;
+// the compaction is abandoned if a checkpoint is committed meanwhile.
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/paddie/statedb"
+	"github.com/paddie/statedb/cmd/internal/cli"
+)
+
+func main() {
+	fsFlags := cli.NewFSFlags()
+	chunkSize := flag.Int("chunksize", statedb.DefaultChunkSize, "chunk size of a content-addressed checkpoint")
+	flag.Parse()
+
+	fs, err := fsFlags.Open()
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	ctx, err := statedb.Compact(fs, &statedb.Options{ChunkSize: *chunkSize})
+	if err == statedb.NothingToCompactError {
+		fmt.Println(err)
+		return
+	}
+	if err != nil {
+		cli.Fatal(err)
+	}
+	fmt.Printf("compacted into %s (%s, %s)\n", ctx.ID(), ctx.ImmPath(), ctx.CtxPath())
+}
@@ -0,0 +1,80 @@
+// Command statedb-diff reports the entries that were added, removed or
+// changed between two checkpoints, given as RCID.MCNT:
+//
+//	statedb-diff -dir /tmp/cpt 3.1 3.7
+//	statedb-diff -bucket mybucket -prefix sim -fields -json 3.1 4.2
+//
+// With -fields, the changed entries are decoded without their Go types
+// (see package dyngob) and diffed field by field.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/paddie/statedb"
+	"github.com/paddie/statedb/cmd/internal/cli"
+)
+
+var marks = map[string]string{
+	statedb.DiffAdded:   "+",
+	statedb.DiffRemoved: "-",
+	statedb.DiffChanged: "~",
+}
+
+func main() {
+	fsFlags := cli.NewFSFlags()
+	jsonFlag := flag.Bool("json", false, "print the report as JSON")
+	fieldsFlag := flag.Bool("fields", false, "diff the changed entries field by field")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		cli.Fatal(fmt.Errorf("usage: statedb-diff [flags] RCID.MCNT RCID.MCNT"))
+	}
+
+	fs, err := fsFlags.Open()
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	report, err := statedb.Diff(fs, flag.Arg(0), flag.Arg(1), &statedb.DiffOptions{
+		Dynamic: *fieldsFlag,
+	})
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(report); err != nil {
+			cli.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("%s -> %s: %d added, %d removed, %d changed\n",
+		report.A.ID(), report.B.ID(),
+		report.Count(statedb.DiffAdded),
+		report.Count(statedb.DiffRemoved),
+		report.Count(statedb.DiffChanged))
+	for _, e := range report.Entries {
+		what := ""
+		if e.Immutable {
+			what += " immutable"
+		}
+		if e.Mutable {
+			what += " mutable"
+		}
+		fmt.Printf("%s %s %s%s\n", marks[e.Change], e.Type, e.Key, what)
+		if e.Err != "" {
+			fmt.Printf("    error: %s\n", e.Err)
+		}
+		for _, f := range e.Fields {
+			fmt.Printf("    %s: %s -> %s\n", f.Path, f.A, f.B)
+		}
+	}
+}
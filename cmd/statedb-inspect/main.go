@@ -0,0 +1,240 @@
+// Command statedb-inspect shows the content of a checkpoint:
+//
+//	statedb-inspect -dir /tmp/cpt
+//	statedb-inspect -bucket mybucket -prefix sim -decode -type main.Particle
+//
+// It prints both contexts, the files of the selected checkpoint, the
+// number of entries per type and the operations of every delta. With
+// -decode, every entry is decoded to JSON from the type information gob
+// stores along with it, so the types of the application are not needed.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/paddie/statedb"
+	"github.com/paddie/statedb/cmd/internal/cli"
+	"github.com/paddie/statedb/dyngob"
+)
+
+var (
+	ctxFlag    = flag.String("ctx", "latest", "context to inspect: latest, 0 or 1")
+	typeFlag   = flag.String("type", "", "only show entries of this type")
+	keyFlag    = flag.String("key", "", "only decode the entry with this key")
+	decodeFlag = flag.Bool("decode", false, "decode the entries to JSON")
+)
+
+// An entry decoded with -decode
+type decoded struct {
+	Type      string      `json:"type"`
+	Key       string      `json:"key"`
+	Source    string      `json:"source"`
+	Version   int         `json:"version,omitempty"`
+	Immutable interface{} `json:"immutable,omitempty"`
+	Mutable   interface{} `json:"mutable,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+func main() {
+	fsFlags := cli.NewFSFlags()
+	flag.Parse()
+
+	fs, err := fsFlags.Open()
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	files := statedb.ReadContexts(fs)
+	ctx, path := selectContext(files)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTEXT\tSIZE\tRCID\tMCNT\tDCNT\tTYPE\tDEDUP\t")
+	for _, cf := range files {
+		mark := ""
+		if cf.Path == path {
+			mark = "*"
+		}
+		if cf.Ctx == nil {
+			fmt.Fprintf(w, "%s%s\t%d\t%v\t\t\t\t\t\n", cf.Path, mark, cf.Size, cf.Err)
+			continue
+		}
+		c := cf.Ctx
+		fmt.Fprintf(w, "%s%s\t%d\t%d\t%d\t%d\t%s\t%v\t\n",
+			cf.Path, mark, cf.Size, c.RCID, c.MCNT, c.DCNT, cptType(c.Type), c.Dedup)
+	}
+	w.Flush()
+
+	if ctx == nil {
+		cli.Fatal(fmt.Errorf("no context to inspect"))
+	}
+
+	snap, err := statedb.ReadSnapshot(fs, ctx)
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	fmt.Printf("\ncheckpoint %s: %s", ctx.ID(), ctx.ImmPath())
+	for _, p := range ctx.DeltaPaths() {
+		fmt.Printf(" <- %s", p)
+	}
+	if ctx.MCNT > 0 {
+		fmt.Printf(" <- %s", ctx.MutPath())
+	}
+	fmt.Println()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tBYTES\t")
+	for _, p := range snap.Paths() {
+		fmt.Fprintf(w, "%s\t%d\t\n", p, snap.Sizes[p])
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tIMMUTABLE\tMUTABLE\tINSERTS\tREMOVES\t")
+	for _, typ := range snap.Types() {
+		if *typeFlag != "" && typ != *typeFlag {
+			continue
+		}
+		ins, rem := 0, 0
+		for _, delta := range snap.Deltas {
+			for _, op := range delta[typ] {
+				if op.Action == statedb.REMOVE {
+					rem++
+				} else {
+					ins++
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t\n",
+			typ, len(snap.Immutable[typ]), len(snap.Mutable[typ]), ins, rem)
+	}
+	w.Flush()
+
+	for i, delta := range snap.Deltas {
+		fmt.Printf("\n%s\n", ctx.DeltaPaths()[i])
+		for _, op := range sortedOps(delta) {
+			action := "INSERT"
+			if op.Action == statedb.REMOVE {
+				action = "REMOVE"
+			}
+			fmt.Printf("  %s %s\n", action, op.KT.String())
+		}
+	}
+
+	if *decodeFlag {
+		fmt.Println()
+		decodeEntries(snap)
+	}
+}
+
+// Returns the context of -ctx and the path it was read from
+func selectContext(files []*statedb.ContextFile) (*statedb.Context, string) {
+	if *ctxFlag == "latest" {
+		ctx := statedb.LatestContext(files)
+		for _, cf := range files {
+			if cf.Ctx == ctx && ctx != nil {
+				return ctx, cf.Path
+			}
+		}
+		return nil, ""
+	}
+	for _, cf := range files {
+		if cf.Path == "cpt"+*ctxFlag+".nfo" {
+			if cf.Ctx == nil {
+				cli.Fatal(fmt.Errorf("%s: %v", cf.Path, cf.Err))
+			}
+			return cf.Ctx, cf.Path
+		}
+	}
+	cli.Fatal(fmt.Errorf("-ctx must be latest, 0 or 1"))
+	return nil, ""
+}
+
+func cptType(t int) string {
+	switch t {
+	case statedb.ZEROCPT:
+		return "zero"
+	case statedb.DELTACPT:
+		return "delta"
+	}
+	return fmt.Sprintf("%d", t)
+}
+
+func sortedOps(delta statedb.DeltaTypeMap) []*statedb.StateOp {
+	ops := []*statedb.StateOp{}
+	for typ, m := range delta {
+		if *typeFlag != "" && typ != *typeFlag {
+			continue
+		}
+		for _, op := range m {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].KT.String() < ops[j].KT.String()
+	})
+	return ops
+}
+
+// Prints every entry of the checkpoint as a line of JSON,
+// the immutable entries first and then the inserts of every delta
+func decodeEntries(snap *statedb.Snapshot) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	emit := func(kt statedb.KeyType, source string, ver int, imm []byte) {
+		if *typeFlag != "" && kt.T != *typeFlag {
+			return
+		}
+		if *keyFlag != "" && kt.K.String() != *keyFlag {
+			return
+		}
+		d := &decoded{
+			Type:    kt.T,
+			Key:     kt.K.String(),
+			Source:  source,
+			Version: ver,
+		}
+		var err error
+		if d.Immutable, err = dyngob.Decode(imm); err != nil {
+			d.Error = err.Error()
+		}
+		if ms := snap.Mutable[kt.T][kt.K]; ms != nil && ms.Val != nil {
+			if d.Mutable, err = dyngob.Decode(ms.Val); err != nil {
+				d.Error = err.Error()
+			}
+		}
+		if err := enc.Encode(d); err != nil {
+			cli.Fatal(err)
+		}
+	}
+
+	ctx := snap.Ctx
+	for _, typ := range snap.Types() {
+		states := snap.Immutable[typ]
+		keys := make([]statedb.Key, 0, len(states))
+		for k := range states {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, k := range keys {
+			s := states[k]
+			emit(s.KT, ctx.ImmPath(), s.Ver, s.Val)
+		}
+	}
+	for i, delta := range snap.Deltas {
+		for _, op := range sortedOps(delta) {
+			if op.Action != statedb.REMOVE {
+				emit(op.KT, ctx.DeltaPaths()[i], op.Ver, op.Val)
+			}
+		}
+	}
+}
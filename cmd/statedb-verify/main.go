@@ -0,0 +1,65 @@
+// Command statedb-verify checks that the retained checkpoints can be
+// restored, and exits with status 1 if they cannot:
+//
+//	statedb-verify -dir /tmp/cpt
+//	statedb-verify -bucket mybucket -prefix sim -json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/paddie/statedb"
+	"github.com/paddie/statedb/cmd/internal/cli"
+)
+
+func main() {
+	fsFlags := cli.NewFSFlags()
+	jsonFlag := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	fs, err := fsFlags.Open()
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	report, err := statedb.Verify(fs)
+	if err != nil {
+		cli.Fatal(err)
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(report); err != nil {
+			cli.Fatal(err)
+		}
+	} else {
+		for _, c := range report.Contexts {
+			status := "ok"
+			if !c.OK() {
+				status = fmt.Sprintf("%d issues", len(c.Issues))
+			}
+			latest := ""
+			if c.Latest {
+				latest = " (latest)"
+			}
+			id := "?"
+			if c.Ctx != nil {
+				id = c.Ctx.ID()
+			}
+			fmt.Printf("%s%s: checkpoint %s, %d immutable, %d mutable: %s\n",
+				c.Path, latest, id, c.Immutable, c.Mutable, status)
+			for _, i := range c.Issues {
+				fmt.Printf("  %s\n", i.String())
+			}
+		}
+	}
+
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
@@ -20,6 +20,9 @@ type CommitReq struct {
 	imm      []byte
 	mut      []byte
 	del      []byte
+	chunks   map[string][]byte // new chunks of a content-addressed zero checkpoint
+	refs     map[string]bool   // every chunk referenced by the manifest
+	prepare  bool              // the context is published by the coordinator
 }
 
 type CommitResp struct {
@@ -32,13 +35,20 @@ type CommitResp struct {
 	imm_dur  time.Duration
 	mut_dur  time.Duration
 	del_dur  time.Duration
+	dur      time.Duration // time until the context was written
+	refs     map[string]bool
+	prepare  bool
 }
 
 func (r *CommitResp) Err() error {
+	// the context is only written once everything else was
+	if r.ctx_err != nil {
+		return r.ctx_err
+	}
 	if r.cpt_type == ZEROCPT {
-		return fmt.Errorf("ZEROCPT:\n\timmutable: %s\n\tmutable: %s", r.imm_err.Error(), r.mut_err.Error())
+		return fmt.Errorf("ZEROCPT:\n\timmutable: %v\n\tmutable: %v", r.imm_err, r.mut_err)
 	} else {
-		return fmt.Errorf("∆CPT:\n\t∆: %s\n\tmut: %s", r.del_err.Error(), r.mut_err.Error())
+		return fmt.Errorf("∆CPT:\n\t∆: %v\n\tmut: %v", r.del_err, r.mut_err)
 	}
 }
 
@@ -78,6 +88,16 @@ func commitLoop(fs Persistence, cnx *CommitNexus) {
 		c := &CommitResp{
 			cpt_type: r.cpt_type,
 			ctx:      r.ctx,
+			refs:     r.refs,
+			prepare:  r.prepare,
+		}
+		// a superseded instance must not overwrite the checkpoints
+		// of the instance that took over
+		if r.ctx.Token > 0 {
+			if c.ctx_err = checkLease(fs, r.ctx.Token); c.ctx_err != nil {
+				cnx.comRespChan <- c
+				continue
+			}
 		}
 		// send the values on different goroutines
 		// to parallelize the writes
@@ -86,7 +106,12 @@ func commitLoop(fs Persistence, cnx *CommitNexus) {
 		// depending on the type of checkpoint
 		if r.cpt_type == ZEROCPT {
 			fmt.Println("Received encoded ZEROCPT")
-			c.imm_dur, c.imm_err = commit_t(fs, r.ctx.ImmPath(), r.imm)
+			var chunk_dur time.Duration
+			chunk_dur, c.imm_err = commitChunks(fs, r.chunks)
+			if c.imm_err == nil {
+				c.imm_dur, c.imm_err = commit_t(fs, r.ctx.ImmPath(), r.imm)
+				c.imm_dur += chunk_dur
+			}
 		} else {
 			fmt.Println("Received encoded ∆CPT")
 			if r.cpt_type == DELTACPT {
@@ -106,14 +131,38 @@ func commitLoop(fs Persistence, cnx *CommitNexus) {
 			continue
 		}
 
-		// encode the context and flip-flop to disk
-		c.ctx_err = commitContext(fs, r.ctx)
+		// a prepared checkpoint is only durable, see coordinate.go
+		if r.prepare {
+			c.dur = time.Now().Sub(start)
+			timeline.Commit(start)
+			cnx.comRespChan <- c
+			continue
+		}
+
+		// encode the context and flip-flop to disk, unless
+		// another instance took over during the commit
+		if r.ctx.Token > 0 {
+			c.ctx_err = checkLease(fs, r.ctx.Token)
+		}
+		if c.ctx_err == nil {
+			c.ctx_err = commitContext(fs, r.ctx)
+		}
+		c.dur = time.Now().Sub(start)
 
 		// note the checkpoint time with the timeline
 		timeline.Commit(start)
 		// send the durations back to statistics module
 		cnx.comRespChan <- c
 
+		// reclaim the chunks no retained context references
+		if c.Success() && r.cpt_type == ZEROCPT && r.ctx.Dedup {
+			if n, err := CollectGarbage(fs); err != nil {
+				fmt.Println(err)
+			} else if n > 0 {
+				fmt.Printf("Collected %d unreferenced chunks\n", n)
+			}
+		}
+
 		// remove previos checkpoint
 		// if c.Success() {
 		// 	err := fs.Delete(c.ctx.CleanPath())
@@ -157,6 +206,17 @@ func async_commit(fs Persistence, path string, data []byte, tc chan<- *TimedComm
 	}
 }
 
+// Writes the new chunks of a content-addressed zero checkpoint
+func commitChunks(fs Persistence, chunks map[string][]byte) (time.Duration, error) {
+	now := time.Now()
+	for hash, data := range chunks {
+		if err := fs.Put(chunkPath(hash), data); err != nil {
+			return time.Now().Sub(now), err
+		}
+	}
+	return time.Now().Sub(now), nil
+}
+
 func commit(fs Persistence, path string, data []byte) error {
 	return fs.Put(path, data)
 }
@@ -0,0 +1,108 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	NothingToCompactError     = errors.New("The most recent checkpoint has no delta checkpoints")
+	ConcurrentCheckpointError = errors.New("A checkpoint was committed during the compaction")
+)
+
+// Compact replays the delta checkpoints of the most recent checkpoint and
+// writes the result as a new reference checkpoint (RCID+1, DCNT=0), so a
+// restore no longer has to retrieve and replay every delta. The new context
+// replaces the older of the two contexts, which leaves the compacted
+// checkpoint as a fallback. Content-addressed checkpoints stay
+// content-addressed, and the Migrations of opts are applied for good.
+//
+// Compact is optimistic: it fails with ConcurrentCheckpointError, and
+// publishes nothing, if another process commits a checkpoint to fs before
+// the new context is written. It is therefore safe to run against a
+// stopped job, or a running one that does not checkpoint to fs.
+func Compact(fs Persistence, opts *Options) (*Context, error) {
+
+	ctx, err := retrieveContext(fs)
+	if err != nil {
+		return nil, NoCheckpointError
+	}
+	if ctx.DCNT == 0 {
+		return nil, NothingToCompactError
+	}
+
+	next, imm, mut, chunks, err := flattenCheckpoint(fs, ctx, opts, ctx.Dedup)
+	if err != nil {
+		return nil, err
+	}
+
+	// never overwrite the reference checkpoint of a running job
+	if _, err := fs.Get(next.ImmPath()); err == nil {
+		return nil, fmt.Errorf("StateDB.Compact: %s already exists: %s", next.ImmPath(), ConcurrentCheckpointError.Error())
+	}
+
+	if _, err := commitChunks(fs, chunks); err != nil {
+		return nil, err
+	}
+	if err := commit(fs, next.ImmPath(), imm); err != nil {
+		return nil, err
+	}
+	if err := commit(fs, next.MutPath(), mut); err != nil {
+		remove(fs, next.ImmPath())
+		return nil, err
+	}
+
+	// publish only if the context is still the most recent
+	latest, err := retrieveContext(fs)
+	if err != nil || *latest != *ctx {
+		remove(fs, next.ImmPath())
+		remove(fs, next.MutPath())
+		return nil, ConcurrentCheckpointError
+	}
+	if err := commitContext(fs, next); err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+// Replays the deltas of the checkpoint of ctx and encodes the result as a
+// new reference checkpoint: its context, the immutable and mutable
+// checkpoints and, if dedup is set, the chunks that are not yet stored.
+func flattenCheckpoint(fs Persistence, ctx *Context, opts *Options, dedup bool) (*Context, []byte, []byte, map[string][]byte, error) {
+
+	db := &StateDB{
+		fs:   fs,
+		opts: opts,
+	}
+	if err := db.load(fs, ctx, false); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// the mutable states are written as they were read
+	for _, states := range db.mutable {
+		for _, ms := range states {
+			ms.frozen = true
+		}
+	}
+
+	next := ctx.newZeroContext()
+	next.Dedup = dedup
+
+	var imm []byte
+	var chunks map[string][]byte
+	var err error
+	if dedup {
+		imm, chunks, _, err = encodeManifest(db.immutable, db.chunks, opts.chunkSize())
+	} else {
+		imm, err = encodeImmutable(db.immutable)
+	}
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	mut, err := encodeMutable(db.mutable, next)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return next, imm, mut, chunks, nil
+}
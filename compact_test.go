@@ -0,0 +1,91 @@
+package statedb
+
+import (
+	"testing"
+)
+
+// Writes a zero checkpoint followed by two delta checkpoints
+func deltaChain(t *testing.T, opts *Options) *memFS {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, opts)
+	defer db.Quit()
+
+	e1, e2 := &ent{ID: 1, Name: "a", m: entMut{Pos: 1}}, &ent{ID: 2, Name: "b"}
+	registerEnts(t, db, e1, e2)
+	commitBlock(t, db, ZEROCPT)
+
+	registerEnts(t, db, &ent{ID: 3, Name: "c", m: entMut{Pos: 3}})
+	commitBlock(t, db, DELTACPT)
+
+	kt2, _ := ReflectKeyTypeM(e2)
+	if err := db.Unregister(kt2); err != nil {
+		t.Fatal(err)
+	}
+	e1.m.Pos = 10
+	commitBlock(t, db, DELTACPT)
+
+	return fs
+}
+
+func TestCompact(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		fs := deltaChain(t, &Options{Dedup: dedup})
+
+		ctx, err := Compact(fs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.RCID != 2 || ctx.DCNT != 0 || ctx.MCNT != 1 || ctx.Dedup != dedup {
+			t.Fatalf("unexpected compacted context: %#v", ctx)
+		}
+		if latest, _ := retrieveContext(fs); *latest != *ctx {
+			t.Fatalf("compacted context was not published: %#v", latest)
+		}
+
+		restored, err := restore(fs, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it, err := Restore[ent](restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := map[int]int{}
+		for it.Next() {
+			pos[it.Value().ID] = it.Value().m.Pos
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if len(pos) != 2 || pos[1] != 10 || pos[3] != 3 {
+			t.Fatalf("unexpected compacted states: %v", pos)
+		}
+
+		if _, err := Compact(fs, nil); err != NothingToCompactError {
+			t.Fatalf("expected NothingToCompactError, got %v", err)
+		}
+	}
+}
+
+func TestCompactConcurrentCheckpoint(t *testing.T) {
+	fs := deltaChain(t, nil)
+	ctx, _ := retrieveContext(fs)
+
+	// a running job commits while the compaction writes
+	newer := ctx.newDeltaContext()
+	fs.onPut = func(name string) {
+		if name == "2/mut_1.cpt" {
+			commitContext(fs, newer)
+		}
+	}
+
+	if _, err := Compact(fs, nil); err != ConcurrentCheckpointError {
+		t.Fatalf("expected ConcurrentCheckpointError, got %v", err)
+	}
+	if latest, _ := retrieveContext(fs); *latest != *newer {
+		t.Fatalf("compaction replaced the context of the running job: %#v", latest)
+	}
+	if fs.has("2/imm.cpt") || fs.has("2/mut_1.cpt") {
+		t.Fatal("aborted compaction left its files behind")
+	}
+}
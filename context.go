@@ -26,11 +26,15 @@ func NewContext() *Context {
 }
 
 type Context struct {
-	RCID  int // reference checkpoint id - the id of the current reference checkpoint
-	DCNT  int // delta count - the number of delta checkpoints since the last reference checkpoint
-	MCNT  int // mutable checkpoints since the last reference checkpoint
-	CtxID int //  0 or 1
-	Type  int
+	RCID   int // reference checkpoint id - the id of the current reference checkpoint
+	DCNT   int // delta count - the number of delta checkpoints since the last reference checkpoint
+	MCNT   int // mutable checkpoints since the last reference checkpoint
+	CtxID  int //  0 or 1
+	Type   int
+	Dedup  bool // immutable checkpoint is content-addressed
+	WALSeq int  // the last operation of the WAL the checkpoint includes
+	GID    int  // the global checkpoint it belongs to, see coordinate.go
+	Token  int  // fencing token of the instance that wrote it, see fence.go
 }
 
 func (ctx *Context) newDeltaContext() *Context {
@@ -88,6 +92,9 @@ func (ctx *Context) ID() string {
 }
 
 func (ctx *Context) ImmPath() string {
+	if ctx.Dedup {
+		return fmt.Sprintf("%d/imm.mft", ctx.RCID)
+	}
 	return fmt.Sprintf("%d/imm.cpt", ctx.RCID)
 }
 
@@ -111,6 +118,21 @@ func (ctx *Context) DeltaPaths() []string {
 	return paths
 }
 
+// Every file a restore from this context reads, the context file last
+func (ctx *Context) Paths() []string {
+	paths := []string{ctx.ImmPath()}
+	paths = append(paths, ctx.DeltaPaths()...)
+	if ctx.MCNT > 0 {
+		paths = append(paths, ctx.MutPath())
+	}
+	return append(paths, ctx.CtxPath())
+}
+
+// Returns true if the path names one of the two flip-flopped context files
+func isContextPath(path string) bool {
+	return path == "cpt0.nfo" || path == "cpt1.nfo"
+}
+
 func (ctx *Context) CleanPath() string {
 	if ctx.Type == DELTACPT {
 		return fmt.Sprintf("%d/mut_%d.cpt", ctx.RCID, ctx.MCNT-1)
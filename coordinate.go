@@ -0,0 +1,491 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A global checkpoint is a checkpoint of every participant, a StateDB
+// opened with Options.Coordinated, taken in two phases by a Coordinator:
+//
+//  1. prepare: every participant encodes a checkpoint at its next point
+//     of consistency and writes it, except for its context
+//  2. commit: once every participant has prepared, each one publishes
+//     its context, which records the global checkpoint id (Context.GID)
+//
+// If a participant fails to prepare, the global checkpoint is aborted
+// and the participants fall back to the context they last published.
+// A restore uses GlobalCheckpoint to pick the most recent global
+// checkpoint every participant retains.
+
+// how long a Coordinator waits for the participants to prepare
+// if Coordinator.Timeout is not set
+const DefaultPrepareTimeout = time.Minute
+
+var (
+	AbortedError        = errors.New("The global checkpoint was aborted")
+	CoordinatedError    = errors.New("The checkpoints of a participant are taken by its coordinator")
+	NotCoordinatedError = errors.New("The database was not opened with Options.Coordinated")
+	ParticipantError    = errors.New("The participant has been shut down")
+)
+
+// Transport delivers the requests of a Coordinator to the participants,
+// and returns their replies
+type Transport interface {
+	Prepare(id string, gid int) error
+	Commit(id string, gid int) error
+	Abort(id string, gid int) error
+}
+
+// A prepare waiting for the next point of consistency
+type prepareReq struct {
+	gid     int
+	err     chan error
+	encoded bool      // the checkpoint is being written
+	aborted chan bool // closed once an abort has been applied
+}
+
+// The global checkpoints of a participant, owned by the stateLoop
+type participation struct {
+	db        *StateDB
+	pending   *prepareReq
+	prepared  *CommitResp // written, but not published
+	published *Context    // the context the participant falls back to
+	floor     int         // every global checkpoint must be newer
+	forceZero bool        // the delta of an aborted checkpoint is lost
+}
+
+func newParticipation(db *StateDB) *participation {
+	p := &participation{
+		db:        db,
+		published: db.ctx.Copy(),
+		floor:     db.ctx.GID,
+	}
+	// a newer context may have been retained by a global
+	// checkpoint that some other participant did not commit
+	for _, cf := range ReadContexts(db.fs) {
+		if cf.Ctx != nil && cf.Ctx.GID > p.floor {
+			p.floor = cf.Ctx.GID
+		}
+	}
+	return p
+}
+
+// Returns the global checkpoint and the type of checkpoint to encode at
+// the point of consistency m, or false if there is none. Called from
+// stateLoop.
+func (p *participation) serves(m *msg) (int, int, bool) {
+	if p.pending == nil || p.pending.encoded {
+		if m.forceCPT {
+			m.err <- CoordinatedError
+		} else {
+			m.err <- nil
+		}
+		return 0, 0, false
+	}
+	cptType := m.cptType
+	if !m.forceCPT {
+		cptType = NONDETERMCPT
+	}
+	if p.forceZero {
+		cptType = ZEROCPT
+	}
+	return p.pending.gid, cptType, true
+}
+
+func (p *participation) encoded() {
+	p.pending.encoded = true
+	p.forceZero = false
+}
+
+// Fails the pending prepare
+func (p *participation) fail(err error) {
+	if req := p.pending; req != nil {
+		p.pending = nil
+		if req.encoded {
+			p.revert()
+		}
+		if req.aborted != nil {
+			close(req.aborted)
+		}
+		req.err <- err
+	}
+}
+
+// Falls back to the published context. The next checkpoint is a zero
+// checkpoint, as the delta of the aborted checkpoint was reset.
+func (p *participation) revert() {
+	*p.db.ctx = *p.published
+	p.forceZero = true
+}
+
+// Holds the written checkpoint of the pending prepare until
+// the coordinator commits or aborts it
+func (db *StateDB) holdPrepared(r *CommitResp) {
+	p := db.part
+	req := p.pending
+	p.pending = nil
+	if req.aborted != nil {
+		p.revert()
+		close(req.aborted)
+		req.err <- AbortedError
+		return
+	}
+	p.prepared = r
+	req.err <- nil
+}
+
+// Participant is the endpoint of a Coordinated StateDB
+// that the Transport of a Coordinator delivers to
+type Participant struct {
+	ID string
+	db *StateDB
+}
+
+// NewParticipant returns the Participant id of the database,
+// which must have been opened with Options.Coordinated
+func NewParticipant(id string, db *StateDB) (*Participant, error) {
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
+	if db.part == nil {
+		return nil, NotCoordinatedError
+	}
+	return &Participant{ID: id, db: db}, nil
+}
+
+// Prepare encodes a checkpoint of the global checkpoint gid at the next
+// point of consistency of the application, and returns once everything
+// but its context has been written.
+func (p *Participant) Prepare(gid int) error {
+	req := &prepareReq{
+		gid: gid,
+		err: make(chan error, 1),
+	}
+	var err error
+	p.db.serve(func() {
+		s := p.db.part
+		switch {
+		case s.pending != nil || s.prepared != nil:
+			err = fmt.Errorf("StateDB.Participant: %s: another global checkpoint is in progress", p.ID)
+		case gid <= s.floor:
+			err = fmt.Errorf("StateDB.Participant: %s: global checkpoint %d is not newer than %d", p.ID, gid, s.floor)
+		default:
+			s.pending = req
+		}
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-req.err:
+		return err
+	case <-p.db.stopped:
+		return ParticipantError
+	}
+}
+
+// Commit publishes the context of the prepared global checkpoint gid
+func (p *Participant) Commit(gid int) error {
+	var err error
+	p.db.serve(func() {
+		err = p.db.publishPrepared(gid)
+	})
+	if err != nil {
+		return fmt.Errorf("StateDB.Participant: %s: %s", p.ID, err.Error())
+	}
+	return nil
+}
+
+// Abort discards the global checkpoint gid, whether it has been prepared
+// or is still waiting for a point of consistency, and refuses it afterwards
+func (p *Participant) Abort(gid int) error {
+	var aborted chan bool
+	p.db.serve(func() {
+		s := p.db.part
+		// an abort may overtake its prepare, which is refused
+		if gid > s.floor {
+			s.floor = gid
+		}
+		if req := s.pending; req != nil && req.gid == gid {
+			if req.encoded {
+				// discarded once it has been written
+				if req.aborted == nil {
+					req.aborted = make(chan bool)
+				}
+				aborted = req.aborted
+				return
+			}
+			s.pending = nil
+			req.err <- AbortedError
+			return
+		}
+		if r := s.prepared; r != nil && r.ctx.GID == gid {
+			s.prepared = nil
+			s.revert()
+		}
+	})
+	if aborted != nil {
+		select {
+		case <-aborted:
+		case <-p.db.stopped:
+			return ParticipantError
+		}
+	}
+	return nil
+}
+
+// Writes the context of the prepared checkpoint. Called from stateLoop.
+func (db *StateDB) publishPrepared(gid int) error {
+	p := db.part
+	r := p.prepared
+	if r == nil || r.ctx.GID != gid {
+		// the coordinator retried a commit that succeeded
+		if p.published.GID == gid {
+			return nil
+		}
+		return fmt.Errorf("global checkpoint %d was not prepared", gid)
+	}
+	if r.ctx.Token > 0 {
+		if err := checkLease(db.fs, r.ctx.Token); err != nil {
+			if err == LostOwnershipError {
+				db.fenced = err
+			}
+			return err
+		}
+	}
+	if err := commitContext(db.fs, r.ctx); err != nil {
+		return err
+	}
+	p.prepared = nil
+	p.published = r.ctx.Copy()
+	p.floor = gid
+
+	db.publish(EventCheckpointCommitted, nil, r.ctx, nil)
+	if wal := db.opts.wal(); wal != nil {
+		go truncateWAL(wal, r.ctx.WALSeq)
+	}
+	if r.cpt_type == ZEROCPT {
+		db.chunks = r.refs
+		// nothing else is written before the next prepare
+		if r.ctx.Dedup {
+			if _, err := CollectGarbage(db.fs); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+	return nil
+}
+
+// LocalTransport delivers the requests of a Coordinator to
+// participants in the same process
+type LocalTransport struct {
+	participants map[string]*Participant
+	sync.RWMutex
+}
+
+func NewLocalTransport(participants ...*Participant) *LocalTransport {
+	t := &LocalTransport{
+		participants: make(map[string]*Participant),
+	}
+	for _, p := range participants {
+		t.Join(p)
+	}
+	return t
+}
+
+// Join adds, or replaces, the participant with the id of p
+func (t *LocalTransport) Join(p *Participant) {
+	t.Lock()
+	defer t.Unlock()
+	t.participants[p.ID] = p
+}
+
+func (t *LocalTransport) participant(id string) (*Participant, error) {
+	t.RLock()
+	defer t.RUnlock()
+	p, ok := t.participants[id]
+	if !ok {
+		return nil, fmt.Errorf("StateDB.LocalTransport: unknown participant %s", id)
+	}
+	return p, nil
+}
+
+func (t *LocalTransport) Prepare(id string, gid int) error {
+	p, err := t.participant(id)
+	if err != nil {
+		return err
+	}
+	return p.Prepare(gid)
+}
+
+func (t *LocalTransport) Commit(id string, gid int) error {
+	p, err := t.participant(id)
+	if err != nil {
+		return err
+	}
+	return p.Commit(gid)
+}
+
+func (t *LocalTransport) Abort(id string, gid int) error {
+	p, err := t.participant(id)
+	if err != nil {
+		return err
+	}
+	return p.Abort(gid)
+}
+
+// Coordinator takes global checkpoints of the participants it
+// reaches through its Transport
+type Coordinator struct {
+	// How long the participants have to prepare.
+	// Defaults to DefaultPrepareTimeout
+	Timeout time.Duration
+	t       Transport
+	ids     []string
+	gid     int
+	sync.Mutex
+}
+
+// NewCoordinator returns a Coordinator of the participants ids. Its global
+// checkpoints continue after last, see GlobalCheckpoint.
+func NewCoordinator(t Transport, ids []string, last int) *Coordinator {
+	return &Coordinator{
+		t:   t,
+		ids: append([]string(nil), ids...),
+		gid: last,
+	}
+}
+
+// Checkpoint takes a global checkpoint and returns its id. It returns
+// once every participant has prepared at its next point of consistency
+// and published its context, or once the checkpoint has been aborted.
+// An id is never reused, even by an aborted checkpoint.
+func (c *Coordinator) Checkpoint() (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.gid++
+	gid := c.gid
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPrepareTimeout
+	}
+	if id, err := c.prepare(gid, timeout); err != nil {
+		c.all(gid, c.t.Abort)
+		return gid, fmt.Errorf("StateDB.Coordinator: global checkpoint %d aborted by %s: %s", gid, id, err.Error())
+	}
+
+	// a participant that did not publish is left at an older
+	// checkpoint, which GlobalCheckpoint accounts for
+	if id, err := c.all(gid, c.t.Commit); err != nil {
+		return gid, fmt.Errorf("StateDB.Coordinator: global checkpoint %d not committed by %s: %s", gid, id, err.Error())
+	}
+	return gid, nil
+}
+
+type vote struct {
+	id  string
+	err error
+}
+
+// Returns the first participant that failed to prepare in time
+func (c *Coordinator) prepare(gid int, timeout time.Duration) (string, error) {
+	// buffered, so late replies never block
+	votes := make(chan *vote, len(c.ids))
+	for _, id := range c.ids {
+		go func(id string) {
+			votes <- &vote{id, c.t.Prepare(id, gid)}
+		}(id)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	prepared := make(map[string]bool)
+	for range c.ids {
+		select {
+		case v := <-votes:
+			if v.err != nil {
+				return v.id, v.err
+			}
+			prepared[v.id] = true
+		case <-deadline.C:
+			for _, id := range c.ids {
+				if !prepared[id] {
+					return id, fmt.Errorf("no reply within %s", timeout)
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+// Sends the request to every participant, and returns the first failure
+func (c *Coordinator) all(gid int, send func(string, int) error) (string, error) {
+	votes := make(chan *vote, len(c.ids))
+	for _, id := range c.ids {
+		go func(id string) {
+			votes <- &vote{id, send(id, gid)}
+		}(id)
+	}
+	var failed *vote
+	for range c.ids {
+		if v := <-votes; v.err != nil && failed == nil {
+			failed = v
+		}
+	}
+	if failed != nil {
+		return failed.id, failed.err
+	}
+	return "", nil
+}
+
+// GlobalCheckpoint returns the most recent global checkpoint whose context
+// every participant retains, to restore with Options.GID, and the most
+// recent global checkpoint any of them retains, for NewCoordinator. It
+// returns NoCheckpointError if they have no global checkpoint in common.
+func GlobalCheckpoint(fss ...Persistence) (int, int, error) {
+	common := map[int]int{}
+	last := 0
+	for _, fs := range fss {
+		seen := map[int]bool{}
+		for _, cf := range ReadContexts(fs) {
+			if cf.Ctx == nil || cf.Ctx.GID == 0 || seen[cf.Ctx.GID] {
+				continue
+			}
+			seen[cf.Ctx.GID] = true
+			common[cf.Ctx.GID]++
+			if cf.Ctx.GID > last {
+				last = cf.Ctx.GID
+			}
+		}
+	}
+
+	gids := []int{}
+	for gid, n := range common {
+		if n == len(fss) {
+			gids = append(gids, gid)
+		}
+	}
+	if len(gids) == 0 {
+		return 0, last, NoCheckpointError
+	}
+	sort.Ints(gids)
+	return gids[len(gids)-1], last, nil
+}
+
+// Returns the retained context of the global checkpoint
+func globalContext(fs Persistence, gid int) (*Context, error) {
+	if gid == 0 {
+		return nil, NoCheckpointError
+	}
+	for _, cf := range ReadContexts(fs) {
+		if cf.Ctx != nil && cf.Ctx.GID == gid {
+			return cf.Ctx, nil
+		}
+	}
+	return nil, fmt.Errorf("StateDB: no retained context of the global checkpoint %d", gid)
+}
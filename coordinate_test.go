@@ -0,0 +1,209 @@
+package statedb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Calls PointOfConsistency, like the main loop of an application,
+// until the returned function is called
+func consistent(t *testing.T, db *StateDB) func() {
+	stop, done := make(chan bool), make(chan bool)
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := db.PointOfConsistency(); err != nil {
+				t.Error(err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// Fails the requests of a phase to some participants
+type failingTransport struct {
+	*LocalTransport
+	prepare map[string]bool
+	commit  map[string]bool
+}
+
+func (f *failingTransport) Prepare(id string, gid int) error {
+	if f.prepare[id] {
+		// long enough for the others to prepare
+		time.Sleep(50 * time.Millisecond)
+		return errors.New("prepare failed")
+	}
+	return f.LocalTransport.Prepare(id, gid)
+}
+
+func (f *failingTransport) Commit(id string, gid int) error {
+	if f.commit[id] {
+		return errors.New("commit failed")
+	}
+	return f.LocalTransport.Commit(id, gid)
+}
+
+func openParticipants(t *testing.T, fss []*memFS, gid int) ([]*StateDB, *LocalTransport) {
+	dbs := make([]*StateDB, len(fss))
+	tr := NewLocalTransport()
+	for i, fs := range fss {
+		dbs[i], _ = openTestDB(t, fs, &Options{Coordinated: true, GID: gid})
+		p, err := NewParticipant(string(rune('a'+i)), dbs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Join(p)
+	}
+	return dbs, tr
+}
+
+func globalID(t *testing.T, fs *memFS) int {
+	ctx, err := retrieveContext(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx.GID
+}
+
+func TestCoordinator(t *testing.T) {
+	fss := []*memFS{newMemFS(), newMemFS()}
+	dbs, tr := openParticipants(t, fss, 0)
+	registerEnts(t, dbs[0], &ent{ID: 1, m: entMut{Pos: 1}})
+	registerEnts(t, dbs[1], &ent{ID: 2, m: entMut{Pos: 2}})
+
+	// the model never checkpoints a participant
+	if err := dbs[0].ForceCheckpoint(); err != CoordinatedError {
+		t.Fatalf("expected CoordinatedError, got %v", err)
+	}
+	if _, err := NewParticipant("c", dbs[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range dbs {
+		defer db.Quit()
+		defer consistent(t, db)()
+	}
+
+	c := NewCoordinator(tr, []string{"a", "b"}, 0)
+	for exp := 1; exp <= 2; exp++ {
+		gid, err := c.Checkpoint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gid != exp {
+			t.Fatalf("expected global checkpoint %d, got %d", exp, gid)
+		}
+		for _, fs := range fss {
+			if g := globalID(t, fs); g != gid {
+				t.Fatalf("expected a published global checkpoint %d, got %d", gid, g)
+			}
+		}
+	}
+	if gid, last, err := GlobalCheckpoint(fss[0], fss[1]); err != nil || gid != 2 || last != 2 {
+		t.Fatalf("unexpected global checkpoint %d (last %d): %v", gid, last, err)
+	}
+}
+
+func TestCoordinatorAbort(t *testing.T) {
+	fss := []*memFS{newMemFS(), newMemFS()}
+	dbs, tr := openParticipants(t, fss, 0)
+	registerEnts(t, dbs[0], &ent{ID: 1})
+	registerEnts(t, dbs[1], &ent{ID: 2})
+	for _, db := range dbs {
+		defer db.Quit()
+		defer consistent(t, db)()
+	}
+
+	ft := &failingTransport{LocalTransport: tr, prepare: map[string]bool{}}
+	c := NewCoordinator(ft, []string{"a", "b"}, 0)
+	if _, err := c.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a has prepared by the time b fails
+	ft.prepare["b"] = true
+	registerEnts(t, dbs[0], &ent{ID: 3})
+	if _, err := c.Checkpoint(); err == nil {
+		t.Fatal("the global checkpoint was not aborted")
+	}
+	if g := globalID(t, fss[0]); g != 1 {
+		t.Fatalf("the aborted global checkpoint was published: %d", g)
+	}
+
+	ft.prepare["b"] = false
+	gid, err := c.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, _ := retrieveContext(fss[0])
+	if gid != 3 || ctx.GID != 3 || ctx.RCID != 2 {
+		t.Fatalf("expected a zero checkpoint of global checkpoint 3, got %#v", ctx)
+	}
+}
+
+func TestGlobalRestore(t *testing.T) {
+	fss := []*memFS{newMemFS(), newMemFS()}
+	dbs, tr := openParticipants(t, fss, 0)
+	e1 := &ent{ID: 1, m: entMut{Pos: 1}}
+	registerEnts(t, dbs[0], e1)
+	registerEnts(t, dbs[1], &ent{ID: 2, m: entMut{Pos: 2}})
+	stops := []func(){consistent(t, dbs[0]), consistent(t, dbs[1])}
+
+	ft := &failingTransport{LocalTransport: tr, commit: map[string]bool{}}
+	c := NewCoordinator(ft, []string{"a", "b"}, 0)
+	if _, err := c.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	// only a publishes global checkpoint 2
+	e1.m.Pos = 10
+	ft.commit["b"] = true
+	if _, err := c.Checkpoint(); err == nil {
+		t.Fatal("the commit did not fail")
+	}
+	for i, db := range dbs {
+		stops[i]()
+		db.Quit()
+	}
+
+	gid, last, err := GlobalCheckpoint(fss[0], fss[1])
+	if err != nil || gid != 1 || last != 2 {
+		t.Fatalf("unexpected global checkpoint %d (last %d): %v", gid, last, err)
+	}
+
+	dbs, tr = openParticipants(t, fss, gid)
+	if pos := restoredPos(t, dbs[0]); len(pos) != 1 || pos[1] != 1 {
+		t.Fatalf("the global checkpoint was not restored: %v", pos)
+	}
+	restoredPos(t, dbs[1])
+	for _, db := range dbs {
+		defer db.Quit()
+		defer consistent(t, db)()
+	}
+
+	// global checkpoint 2 is never reused
+	if _, err := NewCoordinator(tr, []string{"a", "b"}, gid).Checkpoint(); err == nil {
+		t.Fatal("global checkpoint 2 was prepared twice")
+	}
+	c = NewCoordinator(tr, []string{"a", "b"}, last)
+	if gid, err := c.Checkpoint(); err != nil || gid != 3 {
+		t.Fatalf("unexpected global checkpoint %d: %v", gid, err)
+	}
+	if gid, _, _ := GlobalCheckpoint(fss[0], fss[1]); gid != 3 {
+		t.Fatalf("expected global checkpoint 3, got %d", gid)
+	}
+
+	if _, _, err := NewStateDBWithOptions(fss[0], &stubModel{}, &stubMonitor{}, 1.0, "", &Options{Coordinated: true, GID: 7}); err == nil {
+		t.Fatal("restored a global checkpoint that does not exist")
+	}
+}
@@ -32,6 +32,16 @@ func decodeDelta(data []byte) (DeltaTypeMap, error) {
 
 func decodeMutable(data []byte) (MutKeyTypeMap, error) {
 
+	mut, err := decodeMutableID(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return mut.Mutable, nil
+}
+
+func decodeMutableID(data []byte) (*mutableID, error) {
+
 	buff := bytes.NewBuffer(data)
 
 	mut := &mutableID{}
@@ -40,5 +50,5 @@ func decodeMutable(data []byte) (MutKeyTypeMap, error) {
 		return nil, err
 	}
 
-	return mut.Mutable, nil
+	return mut, nil
 }
@@ -0,0 +1,270 @@
+package statedb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+)
+
+// Content-addressed layout of the immutable checkpoint.
+//
+// Instead of writing the entire ImmKeyTypeMap to RCID/imm.cpt, every
+// immutable entry is split into chunks that are stored by their hash
+// in cas/<sha256>. A zero checkpoint then only writes the chunks that
+// are not already referenced by the previous reference checkpoint,
+// along with a manifest in RCID/imm.mft mapping each KeyType to its chunks.
+
+const (
+	DefaultChunkSize = 1 << 16
+	casDir           = "cas"
+)
+
+// An immutable entry stored as a list of content-addressed chunks
+type ImmRef struct {
+	KT     KeyType
+	Ver    int
+	Chunks []string
+}
+
+type manifest struct {
+	Entries map[string]map[Key]*ImmRef
+}
+
+func chunkPath(hash string) string {
+	return path.Join(casDir, hash)
+}
+
+func hashChunk(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Splits every immutable entry into chunks and returns the encoded
+// manifest, the chunks that are not in known and the set of every
+// chunk referenced by the manifest.
+func encodeManifest(immutable ImmKeyTypeMap, known map[string]bool, size int) ([]byte, map[string][]byte, map[string]bool, error) {
+
+	m := &manifest{
+		Entries: make(map[string]map[Key]*ImmRef, len(immutable)),
+	}
+	chunks := make(map[string][]byte)
+	refs := make(map[string]bool)
+
+	for typ, states := range immutable {
+		entries := make(map[Key]*ImmRef, len(states))
+		for k, s := range states {
+			ref := &ImmRef{KT: s.KT, Ver: s.Ver}
+			for i := 0; i < len(s.Val); i += size {
+				end := i + size
+				if end > len(s.Val) {
+					end = len(s.Val)
+				}
+				data := s.Val[i:end]
+				hash := hashChunk(data)
+				ref.Chunks = append(ref.Chunks, hash)
+				refs[hash] = true
+				if !known[hash] {
+					chunks[hash] = data
+				}
+			}
+			entries[k] = ref
+		}
+		m.Entries[typ] = entries
+	}
+
+	data, err := encode(m)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return data, chunks, refs, nil
+}
+
+func decodeManifest(data []byte) (*manifest, error) {
+	m := new(manifest)
+	if err := Decode(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Returns every chunk referenced by the manifest
+func (m *manifest) refs() map[string]bool {
+	refs := make(map[string]bool)
+	for _, entries := range m.Entries {
+		for _, ref := range entries {
+			for _, hash := range ref.Chunks {
+				refs[hash] = true
+			}
+		}
+	}
+	return refs
+}
+
+// Retrieves the manifest and the immutable entries it references.
+// If lazy is set, the chunks are not retrieved and the entries are
+// returned without a value, along with the chunks that make them up.
+func retrieveManifest(fs Persistence, ctx *Context, opts *Options, lazy bool) (ImmKeyTypeMap, map[string]bool, lazyTypes, error) {
+	path := ctx.ImmPath()
+	data, err := fs.Get(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	imm := make(ImmKeyTypeMap, len(m.Entries))
+	pending := make(lazyTypes, len(m.Entries))
+	n := 0
+	for typ, entries := range m.Entries {
+		states := make(ImmStateMap, len(entries))
+		chunks := make(map[Key][]string, len(entries))
+		for k, ref := range entries {
+			states[k] = &ImmState{KT: ref.KT, Ver: ref.Ver}
+			chunks[k] = ref.Chunks
+		}
+		imm[typ] = states
+		pending[typ] = chunks
+		n += len(entries)
+	}
+	opts.report(RestoreManifest, path, len(data), n)
+
+	if !lazy {
+		cache := make(map[string][]byte)
+		for typ := range pending {
+			if _, _, err := fetchType(fs, imm[typ], pending[typ], cache); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		pending = nil
+		opts.report(RestoreImmutable, casDir, cacheSize(cache), n)
+	}
+
+	return imm, m.refs(), pending, nil
+}
+
+// Fills in the value of every entry of a type that is still
+// waiting for its chunks, and returns the number of bytes and
+// entries that were retrieved
+func fetchType(fs Persistence, states ImmStateMap, pending map[Key][]string, cache map[string][]byte) (int, int, error) {
+	bytes, n := 0, 0
+	for k, hashes := range pending {
+		s, ok := states[k]
+		// removed or replaced while replaying the deltas
+		if !ok || s.Val != nil {
+			continue
+		}
+
+		var val []byte
+		for _, hash := range hashes {
+			chunk, ok := cache[hash]
+			if !ok {
+				var err error
+				if chunk, err = fs.Get(chunkPath(hash)); err != nil {
+					return bytes, n, err
+				}
+				if hashChunk(chunk) != hash {
+					return bytes, n, fmt.Errorf("StateDB.Restore: chunk %s of %s is corrupt", hash, s.KT.String())
+				}
+				cache[hash] = chunk
+				bytes += len(chunk)
+			}
+			val = append(val, chunk...)
+		}
+		if val == nil {
+			val = []byte{}
+		}
+		s.Val = val
+		n++
+	}
+	return bytes, n, nil
+}
+
+func cacheSize(cache map[string][]byte) int {
+	n := 0
+	for _, c := range cache {
+		n += len(c)
+	}
+	return n
+}
+
+// Every file a restore from the context reads, including the chunks
+// referenced by a content-addressed immutable checkpoint
+func checkpointFiles(fs Persistence, ctx *Context) ([]string, error) {
+	paths := ctx.Paths()
+	if !ctx.Dedup {
+		return paths, nil
+	}
+
+	data, err := fs.Get(ctx.ImmPath())
+	if err != nil {
+		return nil, err
+	}
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := []string{}
+	for hash := range m.refs() {
+		chunks = append(chunks, chunkPath(hash))
+	}
+	return append(chunks, paths...), nil
+}
+
+// CollectGarbage deletes every chunk that is not referenced by one of the
+// two retained contexts, and returns the number of deleted chunks.
+//
+// It is run by the committer after every content-addressed zero checkpoint,
+// and must not be called while another process is committing to fs.
+func CollectGarbage(fs Persistence) (int, error) {
+
+	refs := make(map[string]bool)
+	for _, name := range []string{"cpt0.nfo", "cpt1.nfo"} {
+		data, err := fs.Get(name)
+		if err != nil {
+			continue
+		}
+		ctx, err := decodeContext(data)
+		if err != nil {
+			return 0, err
+		}
+		if !ctx.Dedup {
+			continue
+		}
+		// never delete anything unless every retained
+		// manifest could be read
+		data, err = fs.Get(ctx.ImmPath())
+		if err != nil {
+			return 0, err
+		}
+		m, err := decodeManifest(data)
+		if err != nil {
+			return 0, err
+		}
+		for hash := range m.refs() {
+			refs[hash] = true
+		}
+	}
+
+	items, err := fs.List(casDir + "/*")
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, item := range items {
+		hash := path.Base(item)
+		if refs[hash] {
+			continue
+		}
+		if err := fs.Delete(chunkPath(hash)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
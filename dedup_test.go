@@ -0,0 +1,98 @@
+package statedb
+
+import (
+	"strings"
+	"testing"
+)
+
+func countChunkPuts(fs *memFS) int {
+	fs.Lock()
+	defer fs.Unlock()
+	n := 0
+	for _, name := range fs.puts {
+		if strings.HasPrefix(name, casDir+"/") {
+			n++
+		}
+	}
+	fs.puts = nil
+	return n
+}
+
+func TestDedupZeroCheckpoint(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, &Options{Dedup: true})
+
+	registerEnts(t, db, &ent{ID: 1, Name: "a"}, &ent{ID: 2, Name: "b"})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunkPuts(fs); n != 2 {
+		t.Fatalf("first zero checkpoint wrote %d chunks, expected 2", n)
+	}
+
+	// only the new entry is written by the next zero checkpoint
+	registerEnts(t, db, &ent{ID: 3, Name: "c"})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunkPuts(fs); n != 1 {
+		t.Fatalf("second zero checkpoint wrote %d chunks, expected 1", n)
+	}
+	if err := db.Quit(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := restore(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored.ctx.Dedup {
+		t.Fatal("restored context is not content-addressed")
+	}
+	if n := restored.immutable.count(); n != 3 {
+		t.Fatalf("restored %d immutable entries, expected 3", n)
+	}
+	for k, s := range restored.immutable[ReflectType(&ent{})] {
+		e := new(ent)
+		if err := Decode(s.Val, e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != k.IntID {
+			t.Fatalf("restored entry %d has ID %d", k.IntID, e.ID)
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	fs := newMemFS()
+
+	imm := make(ImmKeyTypeMap)
+	for i, val := range []string{"a", "b"} {
+		kt, _ := NewIntKeyType(i+1, "t")
+		imm.insert(kt, []byte(val), 0)
+	}
+
+	// a reference checkpoint referencing the chunks of "a" and "b"
+	ctx := NewContext().newZeroContext()
+	ctx.Dedup = true
+	data, chunks, _, _ := encodeManifest(imm, nil, DefaultChunkSize)
+	commitChunks(fs, chunks)
+	fs.Put(ctx.ImmPath(), data)
+	putContext(t, fs, ctx)
+
+	// an unreferenced chunk
+	fs.Put(chunkPath(hashChunk([]byte("c"))), []byte("c"))
+
+	n, err := CollectGarbage(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("collected %d chunks, expected 1", n)
+	}
+	for hash := range chunks {
+		if !fs.has(chunkPath(hash)) {
+			t.Fatal("referenced chunk was collected")
+		}
+	}
+}
@@ -11,6 +11,7 @@ type StateOp struct {
 	KT     KeyType
 	Action int // DELETE=-1 or CREATE=1
 	Val    []byte
+	Ver    int
 }
 
 func (m DeltaTypeMap) count() int {
@@ -41,7 +42,7 @@ func (m DeltaTypeMap) lookup(kt *KeyType) *StateOp {
 	return nil
 }
 
-func (m DeltaTypeMap) insert(kt *KeyType, val []byte) {
+func (m DeltaTypeMap) insert(kt *KeyType, val []byte, ver int) {
 
 	// allocate KeyMap if nil
 	if _, ok := m[kt.TypeID()]; !ok {
@@ -51,6 +52,7 @@ func (m DeltaTypeMap) insert(kt *KeyType, val []byte) {
 	m[kt.TypeID()][kt.K] = &StateOp{
 		KT:     *kt,
 		Val:    val,
+		Ver:    ver,
 		Action: INSERT,
 	}
 }
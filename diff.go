@@ -0,0 +1,335 @@
+package statedb
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paddie/statedb/dyngob"
+)
+
+// Kinds of EntryDiff
+const (
+	DiffAdded   = "added"   // the entry only exists in B
+	DiffRemoved = "removed" // the entry only exists in A
+	DiffChanged = "changed" // the encoded states of the entry differ
+)
+
+// maximum depth of a field-level diff
+const maxDiffDepth = 32
+
+// DiffOptions configure a Diff
+type DiffOptions struct {
+	Options *Options // the Migrations are applied to both checkpoints
+	// Prototypes of registered types (eg. &Particle{}). Changed entries of
+	// these types are restored into new values and diffed field by field.
+	Types []interface{}
+	// Diff the changed entries of every other type field by field, by
+	// decoding them without their Go types (see package dyngob)
+	Dynamic bool
+}
+
+// FieldDiff is a field whose value differs between two checkpoints
+type FieldDiff struct {
+	Path string `json:"path"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+// EntryDiff is an entry that differs between two checkpoints
+type EntryDiff struct {
+	Change    string       `json:"change"`
+	KT        KeyType      `json:"-"`
+	Type      string       `json:"type"`
+	Key       string       `json:"key"`
+	Immutable bool         `json:"immutable,omitempty"` // the encoded immutable state changed
+	Mutable   bool         `json:"mutable,omitempty"`   // the encoded mutable state changed
+	Fields    []*FieldDiff `json:"fields,omitempty"`
+	Err       string       `json:"error,omitempty"` // the entry could not be decoded
+}
+
+// DiffReport is the result of Diff, ordered by KeyType
+type DiffReport struct {
+	A       *Context     `json:"a"`
+	B       *Context     `json:"b"`
+	Entries []*EntryDiff `json:"entries"`
+}
+
+// Count returns the number of entries with the change
+func (r *DiffReport) Count(change string) int {
+	n := 0
+	for _, e := range r.Entries {
+		if e.Change == change {
+			n++
+		}
+	}
+	return n
+}
+
+// Diff restores the checkpoints a and b, given as RCID.MCNT (see
+// Context.ID), and reports the entries that were added, removed or
+// changed between them. Any checkpoint whose files are still in fs can be
+// diffed, not only the two retained contexts.
+//
+// The states are compared by their encoding. As gob encodes maps in no
+// particular order, an entry that is diffed field by field is only
+// reported if one of its fields differs.
+func Diff(fs Persistence, a, b string, opts *DiffOptions) (*DiffReport, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+
+	dba, err := loadCheckpoint(fs, a, opts.Options)
+	if err != nil {
+		return nil, err
+	}
+	dbb, err := loadCheckpoint(fs, b, opts.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]reflect.Type, len(opts.Types))
+	for _, proto := range opts.Types {
+		types[ReflectTypeM(proto)] = Indirect(reflect.ValueOf(proto)).Type()
+	}
+
+	r := &DiffReport{
+		A: dba.ctx,
+		B: dbb.ctx,
+	}
+	for typ, states := range dba.immutable {
+		for k, ia := range states {
+			kt := &KeyType{k, typ}
+			ib := dbb.immutable.lookup(kt)
+			if ib == nil {
+				r.Entries = append(r.Entries, newEntryDiff(DiffRemoved, kt))
+				continue
+			}
+			ma, mb := dba.mutable.lookup(kt), dbb.mutable.lookup(kt)
+			e := newEntryDiff(DiffChanged, kt)
+			e.Immutable = !bytes.Equal(ia.Val, ib.Val)
+			e.Mutable = !bytes.Equal(mutVal(ma), mutVal(mb))
+			if !e.Immutable && !e.Mutable {
+				continue
+			}
+			var err error
+			if t, ok := types[typ]; ok {
+				e.Fields, err = diffTyped(t, &entry{imm: ia, mut: ma, kt: kt}, &entry{imm: ib, mut: mb, kt: kt})
+			} else if opts.Dynamic {
+				e.Fields, err = diffDynamic(ia, ma, ib, mb)
+			}
+			if err != nil {
+				e.Err = err.Error()
+			} else if e.Fields != nil && len(e.Fields) == 0 {
+				// only the order of a map differs
+				continue
+			}
+			r.Entries = append(r.Entries, e)
+		}
+	}
+	for typ, states := range dbb.immutable {
+		for k := range states {
+			kt := &KeyType{k, typ}
+			if !dba.immutable.contains(kt) {
+				r.Entries = append(r.Entries, newEntryDiff(DiffAdded, kt))
+			}
+		}
+	}
+
+	sort.Slice(r.Entries, func(i, j int) bool {
+		return r.Entries[i].KT.String() < r.Entries[j].KT.String()
+	})
+	return r, nil
+}
+
+func newEntryDiff(change string, kt *KeyType) *EntryDiff {
+	return &EntryDiff{
+		Change: change,
+		KT:     *kt,
+		Type:   kt.T,
+		Key:    kt.K.String(),
+	}
+}
+
+func mutVal(ms *MutState) []byte {
+	if ms == nil {
+		return nil
+	}
+	return ms.Val
+}
+
+// Loads the checkpoint with the id RCID.MCNT, and replays its deltas
+func loadCheckpoint(fs Persistence, id string, opts *Options) (*StateDB, error) {
+	ctx, err := resolveContext(fs, id)
+	if err != nil {
+		return nil, err
+	}
+	db := &StateDB{
+		fs:      fs,
+		opts:    opts,
+		mutable: make(MutKeyTypeMap),
+	}
+	if err := db.load(fs, ctx, false); err != nil {
+		return nil, fmt.Errorf("StateDB.Diff: %s: %s", id, err.Error())
+	}
+	return db, nil
+}
+
+// Returns the context of the checkpoint with the id RCID.MCNT: one of the
+// retained contexts, or one rebuilt from the mutable checkpoint
+func resolveContext(fs Persistence, id string) (*Context, error) {
+	parts := strings.Split(id, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("StateDB: invalid checkpoint id %q, expected RCID.MCNT", id)
+	}
+	rcid, err1 := strconv.Atoi(parts[0])
+	mcnt, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || rcid < 1 || mcnt < 1 {
+		return nil, fmt.Errorf("StateDB: invalid checkpoint id %q, expected RCID.MCNT", id)
+	}
+
+	for _, cf := range ReadContexts(fs) {
+		if cf.Ctx != nil && cf.Ctx.RCID == rcid && cf.Ctx.MCNT == mcnt {
+			return cf.Ctx, nil
+		}
+	}
+
+	ctx := &Context{RCID: rcid, MCNT: mcnt}
+	data, err := fs.Get(ctx.MutPath())
+	if err != nil {
+		return nil, fmt.Errorf("StateDB: no checkpoint %s: %s", id, err.Error())
+	}
+	mut, err := decodeMutableID(data)
+	if err != nil {
+		return nil, fmt.Errorf("StateDB: %s: %s", ctx.MutPath(), err.Error())
+	}
+	if mut.RCID == 0 {
+		return nil, fmt.Errorf("StateDB: checkpoint %s predates recorded delta counts, only the retained contexts can be opened", id)
+	}
+	ctx.DCNT = mut.DCNT
+	if mut.DCNT > 0 {
+		ctx.Type = DELTACPT
+	}
+	// content-addressed reference checkpoints have a manifest
+	ctx.Dedup = true
+	if _, err := fs.Get(ctx.ImmPath()); err != nil {
+		ctx.Dedup = false
+	}
+	return ctx, nil
+}
+
+// Restores both entries into new values of type t and diffs them
+func diffTyped(t reflect.Type, a, b *entry) ([]*FieldDiff, error) {
+	va, vb := reflect.New(t), reflect.New(t)
+	if err := a.restore(va.Interface()); err != nil {
+		return nil, err
+	}
+	if err := b.restore(vb.Interface()); err != nil {
+		return nil, err
+	}
+	diffs := []*FieldDiff{}
+	diffValues("", va.Elem(), vb.Elem(), 0, &diffs)
+	return diffs, nil
+}
+
+// Decodes both entries without their Go types and diffs them
+func diffDynamic(ia *ImmState, ma *MutState, ib *ImmState, mb *MutState) ([]*FieldDiff, error) {
+	decode := func(imm *ImmState, mut *MutState) (map[string]interface{}, error) {
+		v := map[string]interface{}{}
+		var err error
+		if v["immutable"], err = dyngob.Decode(imm.Val); err != nil {
+			return nil, err
+		}
+		if mut != nil && mut.Val != nil {
+			if v["mutable"], err = dyngob.Decode(mut.Val); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	}
+	a, err := decode(ia, ma)
+	if err != nil {
+		return nil, err
+	}
+	b, err := decode(ib, mb)
+	if err != nil {
+		return nil, err
+	}
+	diffs := []*FieldDiff{}
+	diffValues("", reflect.ValueOf(a), reflect.ValueOf(b), 0, &diffs)
+	return diffs, nil
+}
+
+// Appends a FieldDiff for every leaf of a and b that differs. Unexported
+// fields are compared too, as the mutable state often is one.
+func diffValues(path string, a, b reflect.Value, depth int, diffs *[]*FieldDiff) {
+
+	leaf := func() {
+		sa, sb := formatValue(a), formatValue(b)
+		if sa != sb {
+			*diffs = append(*diffs, &FieldDiff{Path: path, A: sa, B: sb})
+		}
+	}
+
+	if !a.IsValid() || !b.IsValid() || a.Type() != b.Type() || depth > maxDiffDepth {
+		leaf()
+		return
+	}
+
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			leaf()
+			return
+		}
+		diffValues(path, a.Elem(), b.Elem(), depth+1, diffs)
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			diffValues(joinPath(path, a.Type().Field(i).Name), a.Field(i), b.Field(i), depth+1, diffs)
+		}
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			leaf()
+			return
+		}
+		for i := 0; i < a.Len(); i++ {
+			diffValues(fmt.Sprintf("%s[%d]", path, i), a.Index(i), b.Index(i), depth+1, diffs)
+		}
+	case reflect.Map:
+		keys := map[string]reflect.Value{}
+		for _, k := range a.MapKeys() {
+			keys[fmt.Sprint(k)] = k
+		}
+		for _, k := range b.MapKeys() {
+			keys[fmt.Sprint(k)] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			k := keys[name]
+			diffValues(joinPath(path, name), a.MapIndex(k), b.MapIndex(k), depth+1, diffs)
+		}
+	default:
+		leaf()
+	}
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func formatValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "<none>"
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,64 @@
+package statedb
+
+import (
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	fs := deltaChain(t, nil)
+
+	// 1.1 is no longer a retained context
+	r, err := Diff(fs, "1.1", "1.3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.A.DCNT != 0 || r.B.DCNT != 2 {
+		t.Fatalf("unexpected contexts: %#v %#v", r.A, r.B)
+	}
+	exp := []struct {
+		change, key string
+		mutable     bool
+	}{
+		{DiffChanged, "1", true},
+		{DiffRemoved, "2", false},
+		{DiffAdded, "3", false},
+	}
+	if len(r.Entries) != len(exp) {
+		t.Fatalf("expected %d entries, got %d", len(exp), len(r.Entries))
+	}
+	for i, e := range exp {
+		got := r.Entries[i]
+		if got.Change != e.change || got.Key != e.key || got.Mutable != e.mutable || got.Immutable {
+			t.Fatalf("entry %d: unexpected %#v", i, got)
+		}
+	}
+
+	for _, opts := range []*DiffOptions{
+		{Types: []interface{}{&ent{}}},
+		{Dynamic: true},
+	} {
+		r, err := Diff(fs, "1.2", "1.3", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Count(DiffChanged) != 1 || r.Count(DiffRemoved) != 1 || r.Count(DiffAdded) != 0 {
+			t.Fatalf("unexpected diff: %+v", r.Entries)
+		}
+		fields := r.Entries[0].Fields
+		if len(fields) != 1 || fields[0].A != "1" || fields[0].B != "10" {
+			t.Fatalf("unexpected field diff: %+v", fields)
+		}
+		if path := fields[0].Path; path != "m.Pos" && path != "mutable.Pos" {
+			t.Fatalf("unexpected field path %s", path)
+		}
+	}
+
+	if r, _ := Diff(fs, "1.3", "1.3", nil); len(r.Entries) != 0 {
+		t.Fatalf("a checkpoint differs from itself: %+v", r.Entries)
+	}
+	for _, id := range []string{"1", "x.1", "1.9", "2.1"} {
+		if _, err := Diff(fs, id, "1.3", nil); err == nil {
+			t.Fatalf("diffed the unknown checkpoint %s", id)
+		}
+	}
+}
@@ -0,0 +1,504 @@
+// Package dyngob decodes gob streams without the Go types they were
+// encoded from, by interpreting the type descriptors gob sends along
+// with the values (see the "Encoding Details" of encoding/gob).
+//
+// Values are decoded into JSON-friendly generic values:
+//
+//	struct            map[string]interface{} (zero fields are not transmitted by gob)
+//	map               map[string]interface{} (keys are formatted with fmt.Sprint)
+//	slice, array      []interface{}
+//	[]byte            []byte
+//	int, uint, float  int64, uint64, float64
+//	complex           map[string]interface{}{"real": .., "imag": ..}
+//	interface         map[string]interface{}{"type": name, "value": ..}
+//	GobEncoder etc.   []byte, the types own encoding
+package dyngob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// predefined type ids of encoding/gob
+const (
+	tBool      = 1
+	tInt       = 2
+	tUint      = 3
+	tFloat     = 4
+	tBytes     = 5
+	tString    = 6
+	tComplex   = 7
+	tInterface = 8
+)
+
+const (
+	kindArray = iota
+	kindSlice
+	kindStruct
+	kindMap
+	kindEncoder
+)
+
+// upper bound of any length read from the stream
+const tooBig = 1 << 30
+
+var errBadUint = errors.New("dyngob: corrupt unsigned integer")
+
+type field struct {
+	name string
+	id   int
+}
+
+// A type descriptor received from the stream
+type wireType struct {
+	kind   int
+	name   string
+	elem   int
+	key    int
+	len    int
+	fields []field
+}
+
+// Decoder reads values from a gob stream
+type Decoder struct {
+	r     io.Reader
+	buf   *bytes.Buffer // the current message
+	types map[int]*wireType
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:     r,
+		buf:   new(bytes.Buffer),
+		types: make(map[int]*wireType),
+	}
+}
+
+// Decode decodes a single value gob encoded into data
+func Decode(data []byte) (interface{}, error) {
+	return NewDecoder(bytes.NewReader(data)).Decode()
+}
+
+// Decode returns the next value of the stream, or io.EOF
+// at the end of the stream
+func (d *Decoder) Decode() (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			v, err = nil, e.err
+		}
+	}()
+
+	id, err := d.typeSequence(false)
+	if err != nil {
+		return nil, err
+	}
+	return d.value(id), nil
+}
+
+// TypeName returns the name the encoder gave the type of
+// the value a stream starts with, without decoding the value
+func TypeName(data []byte) (name string, err error) {
+	d := NewDecoder(bytes.NewReader(data))
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(decodeError)
+			if !ok {
+				panic(r)
+			}
+			name, err = "", e.err
+		}
+	}()
+
+	id, err := d.typeSequence(false)
+	if err != nil {
+		return "", err
+	}
+	return d.typeName(id), nil
+}
+
+// errors are propagated as panics and recovered in Decode,
+// like encoding/gob does
+type decodeError struct {
+	err error
+}
+
+func errorf(format string, args ...interface{}) {
+	panic(decodeError{fmt.Errorf("dyngob: "+format, args...)})
+}
+
+// Reads the next message into buf
+func (d *Decoder) recvMessage() error {
+	n, err := readUint(d.r)
+	if err != nil {
+		return err
+	}
+	if n >= tooBig {
+		return fmt.Errorf("dyngob: message too big: %d", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(d.r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	d.buf = bytes.NewBuffer(data)
+	return nil
+}
+
+// Receives type descriptors until the id of a value is read
+func (d *Decoder) typeSequence(isInterface bool) (int, error) {
+	first := true
+	for {
+		if d.buf.Len() == 0 {
+			if err := d.recvMessage(); err != nil {
+				if err == io.EOF && !first {
+					err = io.ErrUnexpectedEOF
+				}
+				return -1, err
+			}
+		}
+		id := d.int()
+		if id >= 0 {
+			return id, nil
+		}
+		d.recvType(-id)
+		// after a type inside an interface there may be
+		// the byte count of a delimited value
+		if d.buf.Len() > 0 {
+			if !isInterface {
+				return -1, errors.New("dyngob: extra data in buffer")
+			}
+			d.uint()
+		}
+		first = false
+	}
+}
+
+func readUint(r io.Reader) (uint64, error) {
+	var b [9]byte
+	if _, err := io.ReadFull(r, b[:1]); err != nil {
+		return 0, err
+	}
+	if b[0] <= 0x7f {
+		return uint64(b[0]), nil
+	}
+	n := -int(int8(b[0]))
+	if n > 8 {
+		return 0, errBadUint
+	}
+	if _, err := io.ReadFull(r, b[1:1+n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	var x uint64
+	for _, c := range b[1 : 1+n] {
+		x = x<<8 | uint64(c)
+	}
+	return x, nil
+}
+
+func (d *Decoder) uint() uint64 {
+	x, err := readUint(d.buf)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		panic(decodeError{err})
+	}
+	return x
+}
+
+func (d *Decoder) int() int {
+	x := d.uint()
+	if x&1 != 0 {
+		return int(^int64(x >> 1))
+	}
+	return int(x >> 1)
+}
+
+func (d *Decoder) length() int {
+	n := d.uint()
+	if n >= tooBig || int(n) > d.buf.Len() {
+		errorf("invalid length %d", n)
+	}
+	return int(n)
+}
+
+func (d *Decoder) bytes() []byte {
+	n := d.length()
+	b := make([]byte, n)
+	copy(b, d.buf.Next(n))
+	return b
+}
+
+func (d *Decoder) float() float64 {
+	return math.Float64frombits(bits.ReverseBytes64(d.uint()))
+}
+
+// Calls fn with the number of every transmitted field of a struct
+func (d *Decoder) fields(fn func(i int)) {
+	i := -1
+	for {
+		delta := d.uint()
+		if delta == 0 {
+			return
+		}
+		if delta >= tooBig {
+			errorf("corrupt field delta %d", delta)
+		}
+		i += int(delta)
+		fn(i)
+	}
+}
+
+// Decodes a wireType, and the types it is made of, for the id
+func (d *Decoder) recvType(id int) {
+	if id < 64 {
+		errorf("type id %d redefines a predefined type", id)
+	}
+
+	var wt *wireType
+	d.fields(func(i int) {
+		if wt != nil {
+			errorf("type %d has several definitions", id)
+		}
+		switch i {
+		case 0: // ArrayT
+			wt = &wireType{kind: kindArray}
+			d.fields(func(i int) {
+				switch i {
+				case 0:
+					wt.name = d.commonType()
+				case 1:
+					wt.elem = d.int()
+				case 2:
+					wt.len = d.int()
+				default:
+					errorf("unknown field %d of arrayType", i)
+				}
+			})
+		case 1: // SliceT
+			wt = &wireType{kind: kindSlice}
+			d.fields(func(i int) {
+				switch i {
+				case 0:
+					wt.name = d.commonType()
+				case 1:
+					wt.elem = d.int()
+				default:
+					errorf("unknown field %d of sliceType", i)
+				}
+			})
+		case 2: // StructT
+			wt = &wireType{kind: kindStruct}
+			d.fields(func(i int) {
+				switch i {
+				case 0:
+					wt.name = d.commonType()
+				case 1:
+					n := d.length()
+					for j := 0; j < n; j++ {
+						f := field{}
+						d.fields(func(i int) {
+							switch i {
+							case 0:
+								f.name = string(d.bytes())
+							case 1:
+								f.id = d.int()
+							default:
+								errorf("unknown field %d of fieldType", i)
+							}
+						})
+						wt.fields = append(wt.fields, f)
+					}
+				default:
+					errorf("unknown field %d of structType", i)
+				}
+			})
+		case 3: // MapT
+			wt = &wireType{kind: kindMap}
+			d.fields(func(i int) {
+				switch i {
+				case 0:
+					wt.name = d.commonType()
+				case 1:
+					wt.key = d.int()
+				case 2:
+					wt.elem = d.int()
+				default:
+					errorf("unknown field %d of mapType", i)
+				}
+			})
+		case 4, 5, 6: // GobEncoderT, BinaryMarshalerT, TextMarshalerT
+			wt = &wireType{kind: kindEncoder}
+			d.fields(func(i int) {
+				if i != 0 {
+					errorf("unknown field %d of gobEncoderType", i)
+				}
+				wt.name = d.commonType()
+			})
+		default:
+			errorf("unknown field %d of wireType", i)
+		}
+	})
+	if wt == nil {
+		errorf("empty definition of type %d", id)
+	}
+	d.types[id] = wt
+}
+
+// Returns the name of a CommonType
+func (d *Decoder) commonType() string {
+	name := ""
+	d.fields(func(i int) {
+		switch i {
+		case 0:
+			name = string(d.bytes())
+		case 1:
+			d.int()
+		default:
+			errorf("unknown field %d of CommonType", i)
+		}
+	})
+	return name
+}
+
+func (d *Decoder) typeName(id int) string {
+	switch id {
+	case tBool:
+		return "bool"
+	case tInt:
+		return "int"
+	case tUint:
+		return "uint"
+	case tFloat:
+		return "float"
+	case tBytes:
+		return "bytes"
+	case tString:
+		return "string"
+	case tComplex:
+		return "complex"
+	case tInterface:
+		return "interface"
+	}
+	if wt, ok := d.types[id]; ok {
+		return wt.name
+	}
+	return fmt.Sprintf("type %d", id)
+}
+
+// Decodes a top-level value, which is a struct or a singleton
+func (d *Decoder) value(id int) interface{} {
+	if wt, ok := d.types[id]; ok && wt.kind == kindStruct {
+		return d.structValue(wt)
+	}
+	if delta := d.uint(); delta != 0 {
+		errorf("corrupted data: non-zero delta for singleton")
+	}
+	return d.field(id)
+}
+
+func (d *Decoder) structValue(wt *wireType) interface{} {
+	m := make(map[string]interface{}, len(wt.fields))
+	d.fields(func(i int) {
+		if i >= len(wt.fields) {
+			errorf("field %d out of range for %s", i, wt.name)
+		}
+		f := wt.fields[i]
+		m[f.name] = d.field(f.id)
+	})
+	return m
+}
+
+// Decodes a value of the type within a struct, slice or map
+func (d *Decoder) field(id int) interface{} {
+	switch id {
+	case tBool:
+		return d.uint() != 0
+	case tInt:
+		return int64(d.int())
+	case tUint:
+		return d.uint()
+	case tFloat:
+		return d.float()
+	case tBytes:
+		return d.bytes()
+	case tString:
+		return string(d.bytes())
+	case tComplex:
+		return map[string]interface{}{
+			"real": d.float(),
+			"imag": d.float(),
+		}
+	case tInterface:
+		return d.interfaceValue()
+	}
+
+	wt, ok := d.types[id]
+	if !ok {
+		errorf("undefined type %d", id)
+	}
+
+	switch wt.kind {
+	case kindStruct:
+		return d.structValue(wt)
+	case kindEncoder:
+		return d.bytes()
+	case kindArray, kindSlice:
+		n := d.length()
+		if wt.kind == kindArray && n != wt.len {
+			errorf("array %s has %d elements, expected %d", wt.name, n, wt.len)
+		}
+		s := make([]interface{}, n)
+		for i := range s {
+			s[i] = d.field(wt.elem)
+		}
+		return s
+	case kindMap:
+		n := d.length()
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			k := d.field(wt.key)
+			m[keyString(k)] = d.field(wt.elem)
+		}
+		return m
+	}
+	errorf("unknown kind of type %s", wt.name)
+	return nil
+}
+
+func (d *Decoder) interfaceValue() interface{} {
+	name := string(d.bytes())
+	if name == "" {
+		return nil
+	}
+	id, err := d.typeSequence(true)
+	if err != nil {
+		panic(decodeError{err})
+	}
+	// byte count of the value
+	d.uint()
+	return map[string]interface{}{
+		"type":  name,
+		"value": d.value(id),
+	}
+}
+
+func keyString(k interface{}) string {
+	if b, ok := k.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(k)
+}
@@ -0,0 +1,183 @@
+package dyngob
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+type inner struct {
+	X, Y float64
+}
+
+type shape interface {
+	Area() float64
+}
+
+type square struct {
+	Side float64
+}
+
+func (s square) Area() float64 { return s.Side * s.Side }
+
+type outer struct {
+	ID       int
+	Name     string
+	Neg      int64
+	Big      uint64
+	Ok       bool
+	Data     []byte
+	Pos      inner
+	Ptr      *inner
+	Path     []inner
+	Grid     [2][2]int
+	Tags     map[string]int
+	ByID     map[int]string
+	C        complex128
+	When     time.Time
+	Shape    shape
+	NilShape shape
+	Self     *outer
+	hidden   int
+}
+
+func init() {
+	gob.Register(square{})
+}
+
+func encode(t *testing.T, vals ...interface{}) []byte {
+	var buff bytes.Buffer
+	enc := gob.NewEncoder(&buff)
+	for _, v := range vals {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buff.Bytes()
+}
+
+func jsonEqual(t *testing.T, got interface{}, exp string) {
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b interface{}
+	json.Unmarshal(data, &a)
+	if err := json.Unmarshal([]byte(exp), &b); err != nil {
+		t.Fatal(err)
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	if !bytes.Equal(ja, jb) {
+		t.Fatalf("decoded\n%s\nexpected\n%s", ja, jb)
+	}
+}
+
+func TestDecodeStruct(t *testing.T) {
+	when := time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+	whenBytes, _ := when.GobEncode()
+	whenJSON, _ := json.Marshal(whenBytes)
+
+	v := &outer{
+		ID:       7,
+		Name:     "particle",
+		Neg:      -1 << 40,
+		Big:      1 << 63,
+		Ok:       true,
+		Data:     []byte("raw"),
+		Pos:      inner{1.5, -2},
+		Ptr:      &inner{X: 3},
+		Path:     []inner{{1, 1}, {}},
+		Grid:     [2][2]int{{1, 2}, {3, 4}},
+		Tags:     map[string]int{"a": 1},
+		ByID:     map[int]string{2: "b"},
+		C:        complex(1, -1),
+		When:     when,
+		Shape:    square{2},
+		NilShape: nil,
+		Self:     &outer{ID: 8},
+		hidden:   1,
+	}
+
+	got, err := Decode(encode(t, v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonEqual(t, got, `{
+		"ID": 7,
+		"Name": "particle",
+		"Neg": -1099511627776,
+		"Big": 9223372036854775808,
+		"Ok": true,
+		"Data": "cmF3",
+		"Pos": {"X": 1.5, "Y": -2},
+		"Ptr": {"X": 3},
+		"Path": [{"X": 1, "Y": 1}, {}],
+		"Grid": [[1, 2], [3, 4]],
+		"Tags": {"a": 1},
+		"ByID": {"2": "b"},
+		"C": {"real": 1, "imag": -1},
+		"When": `+string(whenJSON)+`,
+		"Shape": {"type": "github.com/paddie/statedb/dyngob.square", "value": {"Side": 2}},
+		"Self": {"ID": 8, "Pos": {}, "Grid": [[0, 0], [0, 0]]}
+	}`)
+
+	name, err := TypeName(encode(t, v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "outer" {
+		t.Fatalf("unexpected type name %q", name)
+	}
+}
+
+func TestDecodeSingletons(t *testing.T) {
+	for _, c := range []struct {
+		val interface{}
+		exp string
+	}{
+		{42, `42`},
+		{-3, `-3`},
+		{uint(300), `300`},
+		{"str", `"str"`},
+		{[]byte{1, 2}, `"AQI="`},
+		{[]string{"a", "b"}, `["a","b"]`},
+		{map[string][]int{"a": {1}}, `{"a":[1]}`},
+		{2.25, `2.25`},
+		{true, `true`},
+	} {
+		got, err := Decode(encode(t, c.val))
+		if err != nil {
+			t.Fatalf("%#v: %s", c.val, err)
+		}
+		jsonEqual(t, got, c.exp)
+	}
+}
+
+// Types are only sent the first time they are used in a stream
+func TestDecodeStream(t *testing.T) {
+	data := encode(t, &inner{X: 1}, &inner{Y: 2}, "end")
+	dec := NewDecoder(bytes.NewReader(data))
+	for _, exp := range []string{`{"X":1}`, `{"Y":2}`, `"end"`} {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonEqual(t, got, exp)
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	data := encode(t, &outer{ID: 1, Name: "x"})
+	for _, n := range []int{1, len(data) / 2, len(data) - 1} {
+		if _, err := Decode(data[:n]); err == nil {
+			t.Errorf("decoded a stream truncated to %d bytes", n)
+		}
+	}
+}
@@ -25,17 +25,22 @@ func encodeImmutable(immutable ImmKeyTypeMap) ([]byte, error) {
 	return buff.Bytes(), nil
 }
 
+// The checkpoint a mutable checkpoint belongs to is recorded with it,
+// so it can be restored without its context (see Diff). RCID is 0 in
+// mutable checkpoints written before it was recorded.
 type mutableID struct {
-	// DCNT    int
-	// RCID    int
+	DCNT    int
+	RCID    int
 	MCNT    int
 	Mutable MutKeyTypeMap
 }
 
-func encodeMutable(mutable MutKeyTypeMap, mcnt int) ([]byte, error) {
+func encodeMutable(mutable MutKeyTypeMap, ctx *Context) ([]byte, error) {
 
 	wrap := &mutableID{
-		MCNT: mcnt,
+		DCNT: ctx.DCNT,
+		RCID: ctx.RCID,
+		MCNT: ctx.MCNT,
 	}
 
 	if len(mutable) == 0 {
@@ -0,0 +1,222 @@
+package statedb
+
+import (
+	"errors"
+	"time"
+)
+
+// Kinds of Event
+const (
+	EventInserted            = iota // a state was registered
+	EventRemoved                    // a state was unregistered, or dropped as unrestored
+	EventCheckpointEncoded          // a checkpoint was encoded and handed to the committer
+	EventCheckpointCommitted        // a checkpoint, and its context, were written
+	EventCommitFailed               // a checkpoint could not be written
+	EventTerminationNotice          // the instance is about to be terminated, see HandleTermination
+)
+
+// What happens to a subscriber whose buffer is full
+const (
+	// Discard the event, and count it in Subscription.Dropped
+	SlowDrop = iota
+	// Close the channel of the subscriber, and report SlowConsumerError
+	SlowDisconnect
+)
+
+// buffer of a subscription if EventFilter.Buffer is not set
+const DefaultEventBuffer = 64
+
+var (
+	SlowConsumerError = errors.New("The subscriber did not keep up with the events")
+)
+
+// Event is a change applied by the stateLoop
+type Event struct {
+	Kind int
+	Time time.Time
+	KT   *KeyType // EventInserted and EventRemoved
+	Ctx  *Context // the checkpoint events
+	Err  error    // EventCommitFailed
+}
+
+// EventFilter selects the events of a Subscription. The zero value
+// selects every event.
+type EventFilter struct {
+	Kinds  []int    // only these kinds of events
+	Types  []string // only inserts and removals of these types
+	Buffer int      // events buffered for the subscriber
+	Policy int      // SlowDrop or SlowDisconnect
+}
+
+// Subscription delivers the events selected by its filter on C. C is
+// closed when the subscription is closed, when the database is shut down,
+// and when a SlowDisconnect subscriber falls behind.
+type Subscription struct {
+	C       <-chan *Event
+	c       chan *Event
+	db      *StateDB
+	kinds   map[int]bool
+	types   map[string]bool
+	policy  int
+	dropped int
+	err     error
+	closed  bool
+}
+
+// Subscribe returns a Subscription to the events the stateLoop applies
+// from now on. The stateLoop never waits for a subscriber: once its buffer
+// is full, events are dropped or the subscriber is disconnected, as set by
+// the Policy of the filter.
+func (db *StateDB) Subscribe(filter *EventFilter) *Subscription {
+	if filter == nil {
+		filter = &EventFilter{}
+	}
+	buffer := filter.Buffer
+	if buffer <= 0 {
+		buffer = DefaultEventBuffer
+	}
+	s := &Subscription{
+		c:      make(chan *Event, buffer),
+		db:     db,
+		policy: filter.Policy,
+	}
+	s.C = s.c
+	if len(filter.Kinds) > 0 {
+		s.kinds = make(map[int]bool)
+		for _, k := range filter.Kinds {
+			s.kinds[k] = true
+		}
+	}
+	if len(filter.Types) > 0 {
+		s.types = make(map[string]bool)
+		for _, t := range filter.Types {
+			s.types[t] = true
+		}
+	}
+
+	db.serve(func() {
+		// nothing is published without a running stateLoop
+		if db.query_chan == nil || db.isStopped() {
+			s.close(nil)
+			return
+		}
+		db.subs = append(db.subs, s)
+	})
+	return s
+}
+
+// Close stops the delivery of events and closes C
+func (s *Subscription) Close() {
+	s.db.serve(func() {
+		s.close(nil)
+		s.db.unsubscribe(s)
+	})
+}
+
+// Dropped returns the number of events discarded under SlowDrop
+func (s *Subscription) Dropped() int {
+	n := 0
+	s.db.serve(func() {
+		n = s.dropped
+	})
+	return n
+}
+
+// Err returns SlowConsumerError if the subscriber was disconnected
+func (s *Subscription) Err() error {
+	var err error
+	s.db.serve(func() {
+		err = s.err
+	})
+	return err
+}
+
+func (s *Subscription) close(err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.c)
+}
+
+func (s *Subscription) selects(e *Event) bool {
+	if s.kinds != nil && !s.kinds[e.Kind] {
+		return false
+	}
+	if s.types != nil && e.KT != nil && !s.types[e.KT.T] {
+		return false
+	}
+	return true
+}
+
+// Delivers the event without blocking, and returns false
+// if the subscriber has to be disconnected
+func (s *Subscription) send(e *Event) bool {
+	if !s.selects(e) {
+		return true
+	}
+	select {
+	case s.c <- e:
+		return true
+	default:
+	}
+	if s.policy == SlowDisconnect {
+		s.close(SlowConsumerError)
+		return false
+	}
+	s.dropped++
+	return true
+}
+
+func (db *StateDB) isStopped() bool {
+	select {
+	case <-db.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (db *StateDB) unsubscribe(s *Subscription) {
+	for i, sub := range db.subs {
+		if sub == s {
+			db.subs = append(db.subs[:i], db.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Publishes an event to every subscriber. Called from stateLoop.
+func (db *StateDB) publish(kind int, kt *KeyType, ctx *Context, err error) {
+	if len(db.subs) == 0 {
+		return
+	}
+	e := &Event{
+		Kind: kind,
+		Time: time.Now(),
+		Err:  err,
+	}
+	if kt != nil {
+		k := *kt
+		e.KT = &k
+	}
+	if ctx != nil {
+		e.Ctx = ctx.Copy()
+	}
+	subs := db.subs[:0]
+	for _, s := range db.subs {
+		if s.send(e) {
+			subs = append(subs, s)
+		}
+	}
+	db.subs = subs
+}
+
+// Closes every subscription once the stateLoop returns
+func (db *StateDB) closeSubscriptions() {
+	for _, s := range db.subs {
+		s.close(nil)
+	}
+	db.subs = nil
+}
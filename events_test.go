@@ -0,0 +1,83 @@
+package statedb
+
+import (
+	"testing"
+)
+
+func kinds(s *Subscription, n int) []int {
+	ks := []int{}
+	for i := 0; i < n; i++ {
+		select {
+		case e, ok := <-s.C:
+			if !ok {
+				return ks
+			}
+			ks = append(ks, e.Kind)
+		default:
+			return ks
+		}
+	}
+	return ks
+}
+
+func TestSubscribe(t *testing.T) {
+	db, _ := openTestDB(t, newMemFS(), nil)
+
+	all := db.Subscribe(nil)
+	removed := db.Subscribe(&EventFilter{Kinds: []int{EventRemoved}})
+	other := db.Subscribe(&EventFilter{Types: []string{"other"}})
+	dropping := db.Subscribe(&EventFilter{Buffer: 1})
+	slow := db.Subscribe(&EventFilter{Buffer: 1, Policy: SlowDisconnect})
+
+	e1, e2 := &ent{ID: 1}, &ent{ID: 2}
+	registerEnts(t, db, e1, e2)
+	kt2, _ := ReflectKeyTypeM(e2)
+	if err := db.Unregister(kt2); err != nil {
+		t.Fatal(err)
+	}
+	commitBlock(t, db, ZEROCPT)
+
+	exp := []int{EventInserted, EventInserted, EventRemoved, EventCheckpointEncoded, EventCheckpointCommitted}
+	got := kinds(all, 10)
+	if len(got) != len(exp) {
+		t.Fatalf("expected events %v, got %v", exp, got)
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("expected events %v, got %v", exp, got)
+		}
+	}
+
+	e := <-removed.C
+	if e.Kind != EventRemoved || *e.KT != *kt2 {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if ks := kinds(removed, 10); len(ks) != 0 {
+		t.Fatalf("unexpected events %v", ks)
+	}
+	// the checkpoint events have no type
+	if ks := kinds(other, 10); len(ks) != 2 || ks[0] != EventCheckpointEncoded {
+		t.Fatalf("unexpected events %v", ks)
+	}
+
+	if n := dropping.Dropped(); n != len(exp)-1 {
+		t.Fatalf("expected %d dropped events, got %d", len(exp)-1, n)
+	}
+	if ks := kinds(slow, 10); len(ks) != 1 || slow.Err() != SlowConsumerError {
+		t.Fatalf("slow subscriber was not disconnected: %v %v", ks, slow.Err())
+	}
+
+	removed.Close()
+	if _, ok := <-removed.C; ok {
+		t.Fatal("closed subscription delivered an event")
+	}
+
+	if err := db.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	for range all.C {
+	}
+	if _, ok := <-db.Subscribe(nil).C; ok {
+		t.Fatal("subscription to a stopped database delivered an event")
+	}
+}
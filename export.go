@@ -0,0 +1,261 @@
+package statedb
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	bundleFormat   = "statedb-bundle"
+	bundleVersion  = 1
+	bundleManifest = "BUNDLE.json"
+)
+
+// the files of a checkpoint, relative to the persistence layer
+var checkpointLayout = regexp.MustCompile(`^(cpt[01]\.nfo|[0-9]+/(imm\.cpt|imm\.mft|mut_[0-9]+\.cpt|del_[0-9]+\.cpt)|cas/[0-9a-f]{64})$`)
+
+var (
+	BundleError           = errors.New("The archive is not a valid checkpoint bundle")
+	CheckpointExistsError = errors.New("The persistence layer already holds a checkpoint")
+)
+
+// BundleFile is a file of a checkpoint bundle
+type BundleFile struct {
+	Path   string `json:"path"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Bundle describes a checkpoint bundle, and is its first entry
+type Bundle struct {
+	Format  string        `json:"format"`
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Ctx     *Context      `json:"context"`
+	Files   []*BundleFile `json:"files"` // the context file is last
+}
+
+// Export writes the most recently committed checkpoint of db as a bundle,
+// see Export.
+func (db *StateDB) Export(w io.Writer, flatten bool) error {
+	return Export(db.fs, w, db.opts, flatten)
+}
+
+// Export writes the most recent checkpoint of fs to w as a bundle: a tar
+// archive of a manifest (BUNDLE.json) followed by every file a restore
+// reads, with its size and SHA-256. The chunks of a content-addressed
+// checkpoint are included.
+//
+// If flatten is set and the checkpoint has deltas, they are replayed as
+// done by Compact, and the bundle holds a single reference checkpoint
+// (RCID+1, DCNT=0) that is not content-addressed. fs is never modified.
+func Export(fs Persistence, w io.Writer, opts *Options, flatten bool) error {
+
+	ctx, err := retrieveContext(fs)
+	if err != nil {
+		return NoCheckpointError
+	}
+
+	files := make(map[string][]byte)
+	if flatten && ctx.DCNT > 0 {
+		next, imm, mut, _, err := flattenCheckpoint(fs, ctx, opts, false)
+		if err != nil {
+			return err
+		}
+		ctx = next
+		files[ctx.ImmPath()] = imm
+		files[ctx.MutPath()] = mut
+		if files[ctx.CtxPath()], err = encode(ctx); err != nil {
+			return err
+		}
+	} else {
+		paths, err := checkpointFiles(fs, ctx)
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if files[path], err = fs.Get(path); err != nil {
+				return fmt.Errorf("StateDB.Export: %s: %s", path, err.Error())
+			}
+		}
+	}
+
+	b := &Bundle{
+		Format:  bundleFormat,
+		Version: bundleVersion,
+		Created: time.Now().UTC(),
+		Ctx:     ctx,
+	}
+	// the context is imported last
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		if path != ctx.CtxPath() {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	paths = append(paths, ctx.CtxPath())
+	for _, path := range paths {
+		b.Files = append(b.Files, &BundleFile{
+			Path:   path,
+			Size:   len(files[path]),
+			SHA256: checksum(files[path]),
+		})
+	}
+
+	manifest, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	if err := writeTar(tw, bundleManifest, manifest, b.Created); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := writeTar(tw, path, files[path], b.Created); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// Import reads a bundle written by Export and writes its checkpoint to fs,
+// so a StateDB opened on fs restores it. Every file is checked against the
+// manifest before it is written, and the context is written last, so fs
+// holds no context unless the import succeeds.
+//
+// Import refuses to overwrite an existing checkpoint, and any bundle
+// with a file outside of the layout of a checkpoint.
+func Import(r io.Reader, fs Persistence) (*Context, error) {
+
+	if _, err := retrieveContext(fs); err == nil {
+		return nil, CheckpointExistsError
+	}
+
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleManifest {
+		return nil, BundleError
+	}
+	b := &Bundle{}
+	if err := json.NewDecoder(tr).Decode(b); err != nil {
+		return nil, fmt.Errorf("%s: %s", BundleError.Error(), err.Error())
+	}
+	if b.Format != bundleFormat || b.Ctx == nil || len(b.Files) == 0 {
+		return nil, BundleError
+	}
+	if b.Version > bundleVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", BundleError.Error(), b.Version)
+	}
+
+	expected := make(map[string]*BundleFile, len(b.Files))
+	for _, f := range b.Files {
+		if !bundlePath(f.Path) {
+			return nil, fmt.Errorf("%s: invalid path %q", BundleError.Error(), f.Path)
+		}
+		expected[f.Path] = f
+	}
+	ctxPath := b.Ctx.CtxPath()
+	if expected[ctxPath] == nil {
+		return nil, fmt.Errorf("%s: no context file", BundleError.Error())
+	}
+
+	var ctxData []byte
+	written := []string{}
+	fail := func(err error) (*Context, error) {
+		for _, path := range written {
+			remove(fs, path)
+		}
+		return nil, err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		f := expected[hdr.Name]
+		if f == nil {
+			return fail(fmt.Errorf("%s: unexpected file %s", BundleError.Error(), hdr.Name))
+		}
+		delete(expected, hdr.Name)
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return fail(err)
+		}
+		if len(data) != f.Size || checksum(data) != f.SHA256 {
+			return fail(fmt.Errorf("%s: %s does not match its checksum", BundleError.Error(), f.Path))
+		}
+		if f.Path == ctxPath {
+			ctxData = data
+			continue
+		}
+		if err := commit(fs, f.Path, data); err != nil {
+			return fail(err)
+		}
+		written = append(written, f.Path)
+	}
+
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for path := range expected {
+			missing = append(missing, path)
+		}
+		sort.Strings(missing)
+		return fail(fmt.Errorf("%s: missing %v", BundleError.Error(), missing))
+	}
+
+	ctx, err := decodeContext(ctxData)
+	if err != nil || *ctx != *b.Ctx {
+		return fail(fmt.Errorf("%s: the context does not match the manifest", BundleError.Error()))
+	}
+	if err := commit(fs, ctxPath, ctxData); err != nil {
+		return fail(err)
+	}
+	return ctx, nil
+}
+
+// Whether the path of a bundled file is a file of a checkpoint,
+// and cannot escape the persistence layer
+func bundlePath(p string) bool {
+	if p == "" || path.IsAbs(p) || strings.Contains(p, "..") || path.Clean(p) != p {
+		return false
+	}
+	return checkpointLayout.MatchString(p)
+}
+
+func writeTar(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, bytes.NewReader(data))
+	return err
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
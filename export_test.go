@@ -0,0 +1,163 @@
+package statedb
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestExportImport(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		for _, flatten := range []bool{false, true} {
+			src := deltaChain(t, &Options{Dedup: dedup})
+			before := len(src.files)
+
+			var buff bytes.Buffer
+			if err := Export(src, &buff, nil, flatten); err != nil {
+				t.Fatal(err)
+			}
+			if len(src.files) != before {
+				t.Fatal("export modified the source")
+			}
+
+			dst := newMemFS()
+			ctx, err := Import(&buff, dst)
+			if err != nil {
+				t.Fatalf("dedup=%v flatten=%v: %s", dedup, flatten, err)
+			}
+			if flatten && (ctx.RCID != 2 || ctx.DCNT != 0 || ctx.Dedup) {
+				t.Fatalf("unexpected flattened context: %#v", ctx)
+			}
+			if !flatten && ctx.DCNT != 2 {
+				t.Fatalf("unexpected context: %#v", ctx)
+			}
+			if dst.puts[len(dst.puts)-1] != ctx.CtxPath() {
+				t.Fatalf("the context was not written last: %v", dst.puts)
+			}
+
+			restored, err := restore(dst, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			it, err := Restore[ent](restored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pos := map[int]int{}
+			for it.Next() {
+				pos[it.Value().ID] = it.Value().m.Pos
+			}
+			if it.Err() != nil {
+				t.Fatal(it.Err())
+			}
+			if len(pos) != 2 || pos[1] != 10 || pos[3] != 3 {
+				t.Fatalf("dedup=%v flatten=%v: unexpected imported states: %v", dedup, flatten, pos)
+			}
+
+			if _, err := Import(bytes.NewReader(nil), dst); err != CheckpointExistsError {
+				t.Fatalf("expected CheckpointExistsError, got %v", err)
+			}
+		}
+	}
+}
+
+// Rewrites the bundle with the content of the file name replaced
+func tamper(t *testing.T, bundle []byte, name string, data []byte) []byte {
+	var buff bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(bundle))
+	tw := tar.NewWriter(&buff)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := ioutil.ReadAll(tr)
+		if hdr.Name == name {
+			content = data
+		}
+		if content == nil {
+			continue
+		}
+		hdr.Size = int64(len(content))
+		tw.WriteHeader(hdr)
+		tw.Write(content)
+	}
+	tw.Close()
+	return buff.Bytes()
+}
+
+func TestImportCorrupt(t *testing.T) {
+	src := deltaChain(t, nil)
+	var buff bytes.Buffer
+	if err := Export(src, &buff, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	bundle := buff.Bytes()
+	ctx, _ := retrieveContext(src)
+
+	mut := append([]byte(nil), src.files[ctx.MutPath()]...)
+	mut[len(mut)-1]++
+
+	for name, data := range map[string][]byte{
+		ctx.MutPath():  mut,
+		"1/del_2.cpt":  nil, // dropped
+		bundleManifest: []byte("{}"),
+	} {
+		dst := newMemFS()
+		if _, err := Import(bytes.NewReader(tamper(t, bundle, name, data)), dst); err == nil {
+			t.Fatalf("imported a bundle with a corrupt %s", name)
+		}
+		if len(dst.files) != 0 {
+			t.Fatalf("failed import of a corrupt %s left files behind: %v", name, dst.puts)
+		}
+	}
+}
+
+func TestImportMalicious(t *testing.T) {
+	ctx := &Context{RCID: 1, MCNT: 1}
+	evil := []byte("evil")
+	for _, path := range []string{
+		"../evil",
+		"1/../../evil",
+		"/tmp/evil",
+		"./1/imm.cpt",
+		"1//imm.cpt",
+		"1/evil.cpt",
+		"cas/..",
+		"",
+	} {
+		files := []*BundleFile{
+			{Path: path, Size: len(evil), SHA256: checksum(evil)},
+			{Path: ctx.CtxPath(), Size: len(evil), SHA256: checksum(evil)},
+		}
+		manifest, _ := json.Marshal(&Bundle{Format: bundleFormat, Version: bundleVersion, Ctx: ctx, Files: files})
+
+		var buff bytes.Buffer
+		tw := tar.NewWriter(&buff)
+		writeTar(tw, bundleManifest, manifest, time.Now())
+		writeTar(tw, path, evil, time.Now())
+		writeTar(tw, ctx.CtxPath(), evil, time.Now())
+		tw.Close()
+
+		dst := newMemFS()
+		if _, err := Import(&buff, dst); err == nil {
+			t.Fatalf("imported a bundle with the path %q", path)
+		}
+		if len(dst.puts) != 0 {
+			t.Fatalf("the bundle with the path %q wrote %v", path, dst.puts)
+		}
+	}
+
+	for _, path := range []string{"cpt0.nfo", "12/imm.mft", "3/mut_4.cpt", "3/del_1.cpt", chunkPath(checksum(evil))} {
+		if !bundlePath(path) {
+			t.Fatalf("rejected the checkpoint file %s", path)
+		}
+	}
+}
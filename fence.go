@@ -0,0 +1,107 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The lease of the instance that writes the checkpoints. Every instance
+// that takes over increments its fencing token, and an instance only
+// writes a checkpoint while the lease holds its own token, so the old
+// instance of a spot replacement stops once the new one has started.
+//
+// Persistence offers no compare-and-swap, so two instances that take
+// over at the very same time can both believe they own the lease until
+// one of them reads it back; the token of every Context records which
+// instance wrote it.
+const ownerLock = "owner.lck"
+
+var (
+	LostOwnershipError    = errors.New("Another instance has taken over the checkpoints")
+	LeaseUnavailableError = errors.New("The lease could not be read")
+)
+
+type lease struct {
+	Token    int
+	Owner    string
+	Acquired time.Time
+}
+
+// Returns the lease of fs, or an empty lease if nobody ever acquired it
+func readLease(fs Persistence) (*lease, error) {
+	data, err := fs.Get(ownerLock)
+	if err != nil {
+		ok, lerr := fileExists(fs, ownerLock)
+		if lerr != nil {
+			return nil, fmt.Errorf("%w: %v", LeaseUnavailableError, lerr)
+		}
+		if ok {
+			return nil, fmt.Errorf("%w: %v", LeaseUnavailableError, err)
+		}
+		return &lease{}, nil
+	}
+	l := &lease{}
+	if err := Decode(data, l); err != nil {
+		// possibly read while another instance writes it
+		return nil, fmt.Errorf("%w: %s: %v", LeaseUnavailableError, ownerLock, err)
+	}
+	return l, nil
+}
+
+// Supersedes the current writer of fs and returns the new fencing token
+func acquireLease(fs Persistence, owner string) (int, error) {
+	l, err := readLease(fs)
+	if err != nil {
+		return 0, err
+	}
+	// the lock may have been removed, but the contexts remain
+	token := l.Token
+	for _, cf := range ReadContexts(fs) {
+		if cf.Ctx != nil && cf.Ctx.Token > token {
+			token = cf.Ctx.Token
+		}
+	}
+
+	next := &lease{
+		Token:    token + 1,
+		Owner:    owner,
+		Acquired: time.Now(),
+	}
+	data, err := encode(next)
+	if err != nil {
+		return 0, err
+	}
+	if err := fs.Put(ownerLock, data); err != nil {
+		return 0, err
+	}
+	// another instance may have taken over in the meantime
+	if err := checkLease(fs, next.Token); err != nil {
+		return 0, err
+	}
+	fmt.Printf("StateDB: %s acquired the checkpoints with token %d\n", owner, next.Token)
+	return next.Token, nil
+}
+
+// Returns LostOwnershipError unless the lease holds the token, or
+// LeaseUnavailableError if it could not be read
+func checkLease(fs Persistence, token int) error {
+	l, err := readLease(fs)
+	if err != nil {
+		return err
+	}
+	if l.Token != token {
+		return LostOwnershipError
+	}
+	return nil
+}
+
+// Identifies this instance in the lease
+func defaultOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
@@ -0,0 +1,82 @@
+package statedb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFencing(t *testing.T) {
+	fs := newMemFS()
+	old, _ := openTestDB(t, fs, &Options{Fencing: true, Owner: "old"})
+	defer old.Quit()
+	registerEnts(t, old, &ent{ID: 1})
+	commitBlock(t, old, ZEROCPT)
+
+	// the replacement instance starts while the old one still runs
+	db, restored := openTestDB(t, fs, &Options{Fencing: true, Owner: "new"})
+	defer db.Quit()
+	if !restored {
+		t.Fatal("the replacement was not restored")
+	}
+	restoredPos(t, db)
+
+	if err := old.forceZeroCPTBlock(); err != LostOwnershipError {
+		t.Fatalf("expected LostOwnershipError, got %v", err)
+	}
+	if fs.has("2/imm.cpt") {
+		t.Fatal("the superseded instance wrote a checkpoint")
+	}
+	// and stops checkpointing
+	if err := old.PointOfConsistency(); err != LostOwnershipError {
+		t.Fatalf("expected LostOwnershipError, got %v", err)
+	}
+
+	commitBlock(t, db, ZEROCPT)
+	ctx, _ := retrieveContext(fs)
+	if ctx.RCID != 2 || ctx.Token != 2 {
+		t.Fatalf("unexpected context of the replacement: %#v", ctx)
+	}
+	if l, _ := readLease(fs); l.Token != 2 || l.Owner != "new" {
+		t.Fatalf("unexpected lease: %#v", l)
+	}
+
+	// the token survives the removal of the lock
+	fs.Delete(ownerLock)
+	if token, err := acquireLease(fs, "next"); err != nil || token != 3 {
+		t.Fatalf("expected token 3, got %d: %v", token, err)
+	}
+}
+
+func TestFencingUnavailable(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, &Options{Fencing: true, Owner: "owner"})
+	defer db.Quit()
+	registerEnts(t, db, &ent{ID: 1})
+	commitBlock(t, db, ZEROCPT)
+
+	// a failed read of the lease is no loss of ownership
+	fs.setFailGet(ownerLock, true)
+	if err := db.forceZeroCPTBlock(); !errors.Is(err, LeaseUnavailableError) {
+		t.Fatalf("expected LeaseUnavailableError, got %v", err)
+	}
+	if _, err := acquireLease(fs, "next"); !errors.Is(err, LeaseUnavailableError) {
+		t.Fatalf("acquired the lease without reading it: %v", err)
+	}
+	fs.setFailGet(ownerLock, false)
+
+	// and the delta lost by the failed commit is retried in a zero checkpoint
+	registerEnts(t, db, &ent{ID: 2})
+	commitBlock(t, db, DELTACPT)
+	ctx, _ := retrieveContext(fs)
+	if ctx.RCID != 2 || ctx.Type != ZEROCPT || ctx.Token != 1 {
+		t.Fatalf("unexpected context after the retry: %#v", ctx)
+	}
+	if err := db.PointOfConsistency(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only a lease that does not exist is empty
+	if l, err := readLease(newMemFS()); err != nil || l.Token != 0 {
+		t.Fatalf("expected an empty lease, got %#v: %v", l, err)
+	}
+}
@@ -0,0 +1,269 @@
+package statedb
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// how often a Follower checks for a new context
+// if Options.PollInterval is not set
+const DefaultPollInterval = time.Second
+
+var (
+	FollowerStoppedError = errors.New("The follower has been stopped or promoted")
+)
+
+// Watcher is implemented by a Persistence that can report the files
+// written to it. Watch delivers the name of every written file until
+// stop is closed. A Follower of a Watcher is notified of new contexts
+// instead of polling for them.
+type Watcher interface {
+	Watch(stop <-chan bool) <-chan string
+}
+
+// Follower keeps a warm, in-memory copy of the most recent checkpoint a
+// running job has committed to fs, as a hot standby. See Follow.
+type Follower struct {
+	fs     Persistence // the Persistence of the job
+	opts   *Options
+	db     *StateDB // the followed states, guarded by mu
+	mu     sync.RWMutex
+	syncMu sync.Mutex // serializes Sync
+	err    error      // of the most recent Sync
+	stop   chan bool
+	done   chan bool
+	once   sync.Once
+}
+
+// Follow restores the most recent checkpoint of fs and keeps applying the
+// checkpoints committed after it. A new delta checkpoint only retrieves
+// the new delta and mutable checkpoints; a new zero checkpoint is
+// restored in full. Nothing is ever written to fs.
+//
+// Follow can start before the job commits its first checkpoint. When the
+// job dies, Promote turns the follower into a writable StateDB.
+func Follow(fs Persistence, opts *Options) (*Follower, error) {
+	f := &Follower{
+		fs:   fs,
+		opts: opts,
+		db:   newFollowerDB(fs, opts),
+		stop: make(chan bool),
+		done: make(chan bool),
+	}
+	if err := f.Sync(); err != nil && err != NoCheckpointError {
+		return nil, err
+	}
+	go f.follow()
+	return f, nil
+}
+
+func newFollowerDB(fs Persistence, opts *Options) *StateDB {
+	return &StateDB{
+		fs:        readOnlyFS{fs},
+		opts:      opts,
+		readOnly:  true,
+		immutable: make(ImmKeyTypeMap),
+		mutable:   make(MutKeyTypeMap),
+		ctx:       NewContext(),
+	}
+}
+
+func (f *Follower) follow() {
+	defer close(f.done)
+
+	var tick <-chan time.Time
+	var events <-chan string
+	if w, ok := f.fs.(Watcher); ok {
+		events = w.Watch(f.stop)
+	} else {
+		ticker := time.NewTicker(f.opts.pollInterval())
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-tick:
+		case name, ok := <-events:
+			if !ok {
+				return
+			}
+			// the context is written last
+			if !isContextPath(name) {
+				continue
+			}
+		}
+		f.Sync()
+	}
+}
+
+// Sync applies the checkpoints committed since the last one the follower
+// applied, and returns once it is up to date with fs.
+func (f *Follower) Sync() error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+
+	err := f.sync()
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+	return err
+}
+
+func (f *Follower) sync() error {
+	f.mu.RLock()
+	db := f.db
+	f.mu.RUnlock()
+	if db == nil {
+		return FollowerStoppedError
+	}
+
+	next, err := retrieveContext(f.fs)
+	if err != nil {
+		return NoCheckpointError
+	}
+	cur := db.ctx
+	if next.RCID < cur.RCID || (next.RCID == cur.RCID && next.MCNT <= cur.MCNT) {
+		return nil
+	}
+
+	if next.RCID == cur.RCID && next.DCNT >= cur.DCNT {
+		if err := f.apply(db, next); err == nil {
+			return nil
+		}
+	}
+	return f.reload(next)
+}
+
+// Retrieves the new delta and mutable checkpoints of next,
+// and applies them to the followed states
+func (f *Follower) apply(db *StateDB, next *Context) error {
+	deltas, err := retrieveDeltaPaths(db.fs, next.DeltaPaths()[db.ctx.DCNT:], f.opts)
+	if err != nil {
+		return err
+	}
+	mut, err := retrieveMutable(db.fs, next, f.opts)
+	if err != nil {
+		return err
+	}
+	if err := f.opts.migrations().migrateMutable(mut); err != nil {
+		return err
+	}
+
+	// replayed into a copy, so a failed replay leaves the followed states
+	// as they were, consistent with the context of the last checkpoint
+	staged := &StateDB{
+		opts:      db.opts,
+		immutable: db.immutable.clone(),
+		mutable:   mut,
+	}
+	if err := staged.replayDeltas(deltas); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	db.immutable, db.mutable, db.ctx = staged.immutable, staged.mutable, next
+	return nil
+}
+
+// Restores the checkpoint of next in full
+func (f *Follower) reload(next *Context) error {
+	db := newFollowerDB(f.fs, f.opts)
+	if err := db.load(db.fs, next, false); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.db == nil {
+		return FollowerStoppedError
+	}
+	f.db = db
+	return nil
+}
+
+// Stop stops following the checkpoints of the job
+func (f *Follower) Stop() {
+	f.once.Do(func() {
+		close(f.stop)
+	})
+	<-f.done
+}
+
+// Err returns the error of the most recent attempt to catch up
+func (f *Follower) Err() error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.err
+}
+
+// Context returns the context of the most recent checkpoint applied
+func (f *Follower) Context() *Context {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.db == nil {
+		return nil
+	}
+	return f.db.ctx.Copy()
+}
+
+// Types returns every type with a followed state, sorted
+func (f *Follower) Types() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.db == nil {
+		return nil
+	}
+	return f.db.Types()
+}
+
+// Count returns the number of followed states of the type
+func (f *Follower) Count(typeID string) int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.db == nil {
+		return 0
+	}
+	return f.db.Count(typeID)
+}
+
+// Get returns a copy of the followed state with the KeyType
+func (f *Follower) Get(kt *KeyType) (*Entry, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.db == nil {
+		return nil, FollowerStoppedError
+	}
+	return f.db.Get(kt)
+}
+
+// Promote stops following, applies the checkpoints committed since the
+// last poll, and starts the followed states as a writable StateDB, like
+// NewStateDBWithOptions would after a restore but without retrieving the
+// checkpoint again. The restored mutable states still have to be
+// reattached through RestoreIter, RestoreSingle or Restore.
+//
+// The job must have stopped: nothing prevents both from checkpointing
+// to fs otherwise.
+func (f *Follower) Promote(model Model, monitor Monitor, bid float64, path string) (*StateDB, bool, error) {
+	f.Stop()
+	if err := f.Sync(); err != nil && err != NoCheckpointError {
+		return nil, false, err
+	}
+
+	f.mu.Lock()
+	db := f.db
+	f.db = nil
+	f.mu.Unlock()
+	if db == nil {
+		return nil, false, FollowerStoppedError
+	}
+
+	db.readOnly = false
+	db.restored = db.ctx.RCID > 0
+	db.delta = make(DeltaTypeMap)
+	return db.start(f.fs, model, monitor, bid, path, f.opts, true)
+}
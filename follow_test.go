@@ -0,0 +1,208 @@
+package statedb
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the names retrieved from a memFS
+type getsFS struct {
+	*memFS
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (g *getsFS) Get(name string) ([]byte, error) {
+	g.mu.Lock()
+	g.gets[name]++
+	g.mu.Unlock()
+	return g.memFS.Get(name)
+}
+
+func (g *getsFS) count(name string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gets[name]
+}
+
+// Reports the Puts to a memFS as a Watcher
+type watchFS struct {
+	*memFS
+}
+
+func (w watchFS) Watch(stop <-chan bool) <-chan string {
+	c := make(chan string, 16)
+	w.memFS.Lock()
+	w.memFS.onPut = func(name string) {
+		select {
+		case c <- name:
+		case <-stop:
+		}
+	}
+	w.memFS.Unlock()
+	return c
+}
+
+// Waits for the follower to apply the checkpoint with the id
+func caughtUp(t *testing.T, f *Follower, id string) {
+	for i := 0; ; i++ {
+		if f.Context().ID() == id {
+			return
+		}
+		if i == 200 {
+			t.Fatalf("follower is at %s, expected %s: %v", f.Context().ID(), id, f.Err())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	fs := &getsFS{memFS: newMemFS(), gets: map[string]int{}}
+	db, _ := openTestDB(t, fs, nil)
+
+	// no checkpoint has been committed yet
+	f, err := Follow(fs, &Options{PollInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	e1, e2 := &ent{ID: 1, m: entMut{Pos: 1}}, &ent{ID: 2}
+	registerEnts(t, db, e1, e2)
+	commitBlock(t, db, ZEROCPT)
+	caughtUp(t, f, "1.1")
+	if n := f.Count(ReflectTypeM(&ent{})); n != 2 {
+		t.Fatalf("expected 2 followed states, found %d", n)
+	}
+
+	registerEnts(t, db, &ent{ID: 3, m: entMut{Pos: 3}})
+	kt2, _ := ReflectKeyTypeM(e2)
+	if err := db.Unregister(kt2); err != nil {
+		t.Fatal(err)
+	}
+	e1.m.Pos = 10
+	commitBlock(t, db, DELTACPT)
+	caughtUp(t, f, "1.2")
+
+	// only the new delta and mutable checkpoints were retrieved
+	if n := fs.count("1/imm.cpt"); n != 1 {
+		t.Fatalf("the immutable checkpoint was retrieved %d times", n)
+	}
+	if n := fs.count("1/del_1.cpt"); n != 1 {
+		t.Fatalf("the delta checkpoint was retrieved %d times", n)
+	}
+	kt1, _ := ReflectKeyTypeM(e1)
+	if e, err := f.Get(kt1); err != nil || e.Mutable == nil {
+		t.Fatalf("the mutable state was not followed: %v %v", e, err)
+	}
+	if _, err := f.Get(kt2); err == nil {
+		t.Fatal("the removed state is still followed")
+	}
+
+	// a new reference checkpoint is restored in full
+	commitBlock(t, db, ZEROCPT)
+	caughtUp(t, f, "2.1")
+	if n := f.Count(ReflectTypeM(&ent{})); n != 2 {
+		t.Fatalf("expected 2 followed states, found %d", n)
+	}
+	db.Quit()
+}
+
+func TestFollowWatch(t *testing.T) {
+	fs := watchFS{newMemFS()}
+	db, _ := openTestDB(t, fs, nil)
+	defer db.Quit()
+
+	// polling would never catch up
+	f, err := Follow(fs, &Options{PollInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	registerEnts(t, db, &ent{ID: 1})
+	commitBlock(t, db, ZEROCPT)
+	caughtUp(t, f, "1.1")
+}
+
+func TestFollowFailedReplay(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	defer db.Quit()
+
+	f, err := Follow(fs, &Options{PollInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	e1 := &ent{ID: 1, m: entMut{Pos: 1}}
+	registerEnts(t, db, e1)
+	commitBlock(t, db, ZEROCPT)
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	kt1, _ := ReflectKeyTypeM(e1)
+	before, err := f.Get(kt1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e1.m.Pos = 10
+	registerEnts(t, db, &ent{ID: 2})
+	commitBlock(t, db, DELTACPT)
+
+	// the delta removes a state that does not exist, and
+	// the full restore the follower falls back to fails too
+	kt9, _ := ReflectKeyTypeM(&ent{ID: 9})
+	delta := DeltaTypeMap{}
+	delta.remove(kt9)
+	data, err := encodeDelta(delta, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Put("1/del_1.cpt", data)
+	fs.setFailGet("1/imm.cpt", true)
+
+	if err := f.Sync(); err == nil {
+		t.Fatal("the corrupt delta checkpoint was applied")
+	}
+	if id := f.Context().ID(); id != "1.1" {
+		t.Fatalf("expected the follower to stay at 1.1, got %s", id)
+	}
+	if e, err := f.Get(kt1); err != nil || string(e.Mutable) != string(before.Mutable) {
+		t.Fatalf("the followed states were changed by the failed replay: %v %v", e, err)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	fs := deltaChain(t, nil)
+
+	f, err := Follow(fs, &Options{PollInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, restored, err := f.Promote(&stubModel{}, &stubMonitor{}, 1.0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Quit()
+	if !restored {
+		t.Fatal("the promoted database was not restored")
+	}
+	if _, err := f.Get(&KeyType{}); err != FollowerStoppedError {
+		t.Fatalf("expected FollowerStoppedError, got %v", err)
+	}
+
+	if pos := restoredPos(t, db); len(pos) != 2 || pos[1] != 10 || pos[3] != 3 {
+		t.Fatalf("unexpected promoted states: %v", pos)
+	}
+
+	// the promoted database continues the checkpoints of the job
+	registerEnts(t, db, &ent{ID: 4})
+	commitBlock(t, db, DELTACPT)
+	if ctx, _ := retrieveContext(fs); ctx.ID() != "1.4" || ctx.DCNT != 3 {
+		t.Fatalf("unexpected context after the promotion: %#v", ctx)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"launchpad.net/goamz/aws"
 	"launchpad.net/goamz/s3"
 	"path/filepath"
+	"strings"
 )
 
 type FS_S3 struct {
@@ -54,18 +55,32 @@ func (b *FS_S3) Delete(path string) error {
 	return b.fs.Del(filepath.Join(b.dir, path))
 }
 
+// S3 only supports prefix listing, so a trailing '*' in the
+// pattern is treated as "every key with this prefix".
 func (b *FS_S3) List(pattern string) ([]string, error) {
 
-	resp, err := b.fs.List(filepath.Join(b.dir, pattern), "/", "", 1000)
-	if err != nil {
-		return nil, err
+	prefix := filepath.Join(b.dir, strings.TrimSuffix(pattern, "*"))
+	if strings.HasSuffix(pattern, "/*") {
+		prefix += "/"
 	}
 
-	items := make([]string, 0, len(resp.Contents))
-	for _, k := range resp.Contents {
-		items = append(items, k.Key)
+	items := []string{}
+	marker := ""
+	for {
+		resp, err := b.fs.List(prefix, "/", marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range resp.Contents {
+			items = append(items, k.Key)
+		}
+
+		if !resp.IsTruncated || len(resp.Contents) == 0 {
+			return items, nil
+		}
+		marker = resp.Contents[len(resp.Contents)-1].Key
 	}
-	return items, err
 }
 
 func (b *FS_S3) Volume() string {
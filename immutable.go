@@ -13,6 +13,7 @@ type ImmStateMap map[Key]*ImmState
 type ImmState struct {
 	KT  KeyType // save keytype to match against in testing
 	Val []byte  // serialised object
+	Ver int     // version of the type the object was serialised from
 }
 
 func (m ImmKeyTypeMap) count() int {
@@ -43,7 +44,7 @@ func (m ImmKeyTypeMap) lookup(kt *KeyType) *ImmState {
 	return nil
 }
 
-func (m ImmKeyTypeMap) insert(kt *KeyType, val []byte) {
+func (m ImmKeyTypeMap) insert(kt *KeyType, val []byte, ver int) {
 
 	// allocate KeyMap if nil
 	if _, ok := m[kt.TypeID()]; !ok {
@@ -53,6 +54,7 @@ func (m ImmKeyTypeMap) insert(kt *KeyType, val []byte) {
 	m[kt.TypeID()][kt.K] = &ImmState{
 		KT:  *kt,
 		Val: val,
+		Ver: ver,
 	}
 }
 
@@ -67,3 +69,17 @@ func (m ImmKeyTypeMap) remove(kt *KeyType) {
 		}
 	}
 }
+
+// Returns a copy of the map that can be modified without changing m.
+// The states are shared, as they are replaced rather than updated.
+func (m ImmKeyTypeMap) clone() ImmKeyTypeMap {
+	c := make(ImmKeyTypeMap, len(m))
+	for typ, states := range m {
+		cs := make(ImmStateMap, len(states))
+		for k, s := range states {
+			cs[k] = s
+		}
+		c[typ] = cs
+	}
+	return c
+}
@@ -34,22 +34,30 @@ import (
 // 3. If object is not in the Delat (meaning in was created in a previous CPT), insert a REMOVE entry for that particular KeyType in the Delta
 // 4. If the key is not in StateDB, return error
 func (db *StateDB) Unregister(kt *KeyType) error {
+	if err := db.writable(); err != nil {
+		return err
+	}
 
 	if !kt.IsValid() {
 		return errors.New("StateDB.Remove: invalid keytype " + kt.String())
 	}
 
 	err_chan := make(chan error)
-	db.op_chan <- &stateOperation{
+	if err := db.sendOp(&stateOperation{
 		kt:     kt,
 		action: REMOVE,
 		err:    err_chan,
+	}); err != nil {
+		return err
 	}
 
 	return <-err_chan
 }
 
 func (db *StateDB) Register(i interface{}) (*KeyType, error) {
+	if err := db.writable(); err != nil {
+		return nil, err
+	}
 
 	// we allow for the mutable state to be <nil>
 	if i == nil {
@@ -75,6 +83,9 @@ func (db *StateDB) Register(i interface{}) (*KeyType, error) {
 		action: INSERT,
 		err:    err_chan,
 	}
+	if v, ok := i.(Versioner); ok {
+		so.ver = v.Version()
+	}
 	// if the mutable state is nil, we also validate and encode it
 	m, ok := i.(Mutable)
 	if ok {
@@ -88,6 +99,7 @@ func (db *StateDB) Register(i interface{}) (*KeyType, error) {
 			so.mut = &MutState{
 				KT:  *kt,
 				Val: nil,
+				Ver: so.ver,
 				v:   mutv,
 			}
 		}
@@ -95,7 +107,9 @@ func (db *StateDB) Register(i interface{}) (*KeyType, error) {
 
 	// ship the operation to be inserted
 	// fmt.Println("Inserting kt: ", kt.String())
-	db.op_chan <- so
+	if err := db.sendOp(so); err != nil {
+		return nil, err
+	}
 
 	// wait for response
 	return kt, <-err_chan
@@ -0,0 +1,150 @@
+package statedb
+
+import (
+	"path"
+	"sort"
+)
+
+// ContextFile is one of the two context files a checkpoint
+// is restored from, see ReadContexts
+type ContextFile struct {
+	Path    string
+	Size    int
+	Ctx     *Context // <nil> if the file is missing, unreadable or corrupt
+	Missing bool     // the file does not exist
+	Err     error
+}
+
+// ReadContexts reads both context files, whether or not they exist
+func ReadContexts(fs Persistence) []*ContextFile {
+	files := make([]*ContextFile, 0, 2)
+	for _, name := range []string{"cpt0.nfo", "cpt1.nfo"} {
+		cf := &ContextFile{Path: name}
+		data, err := fs.Get(name)
+		if err != nil {
+			cf.Err = err
+			if ok, lerr := fileExists(fs, name); lerr != nil {
+				cf.Err = lerr
+			} else {
+				cf.Missing = !ok
+			}
+		} else {
+			cf.Size = len(data)
+			cf.Ctx, cf.Err = decodeContext(data)
+		}
+		files = append(files, cf)
+	}
+	return files
+}
+
+// Reports whether the file exists, as Persistence
+// does not tell a missing file from a failed read
+func fileExists(fs Persistence, name string) (bool, error) {
+	items, err := fs.List(name)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range items {
+		if path.Base(item) == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LatestContext returns the most recent of the contexts that could be read
+func LatestContext(files []*ContextFile) *Context {
+	var ctx *Context
+	for _, cf := range files {
+		if cf.Ctx == nil {
+			continue
+		}
+		if ctx == nil {
+			ctx = cf.Ctx
+		} else {
+			ctx = MostRecent(ctx, cf.Ctx)
+		}
+	}
+	return ctx
+}
+
+// Snapshot is the content of a checkpoint as it is stored: the reference
+// checkpoint, the most recent mutable checkpoint and every delta
+// checkpoint, before the deltas are replayed.
+type Snapshot struct {
+	Ctx       *Context
+	Immutable ImmKeyTypeMap
+	Mutable   MutKeyTypeMap
+	Deltas    []DeltaTypeMap // Deltas[i] is the checkpoint RCID/del_{i+1}.cpt
+	Sizes     map[string]int // bytes read, by path. The chunks of a content-addressed checkpoint are summed up under "cas"
+}
+
+// ReadSnapshot reads every file of the checkpoint described by ctx
+func ReadSnapshot(fs Persistence, ctx *Context) (*Snapshot, error) {
+
+	s := &Snapshot{
+		Ctx:     ctx,
+		Mutable: make(MutKeyTypeMap),
+		Sizes:   make(map[string]int),
+	}
+	opts := &Options{
+		Progress: func(p RestoreProgress) {
+			if p.Path != "" {
+				s.Sizes[p.Path] += p.Bytes
+			}
+		},
+	}
+
+	var err error
+	if ctx.Dedup {
+		s.Immutable, _, _, err = retrieveManifest(fs, ctx, opts, false)
+	} else {
+		s.Immutable, err = retrieveImmutable(fs, ctx, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.MCNT > 0 {
+		if s.Mutable, err = retrieveMutable(fs, ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.DCNT > 0 {
+		if s.Deltas, err = retrieveDeltas(fs, ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Types returns every type in the immutable checkpoint or one of the deltas
+func (s *Snapshot) Types() []string {
+	seen := make(map[string]bool)
+	for typ := range s.Immutable {
+		seen[typ] = true
+	}
+	for _, delta := range s.Deltas {
+		for typ := range delta {
+			seen[typ] = true
+		}
+	}
+	types := make([]string, 0, len(seen))
+	for typ := range seen {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Paths returns the path of every file that was read, sorted
+func (s *Snapshot) Paths() []string {
+	paths := make([]string, 0, len(s.Sizes))
+	for path := range s.Sizes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,52 @@
+package statedb
+
+import (
+	"testing"
+)
+
+func TestReadSnapshot(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	defer db.Quit()
+
+	e1, e2 := &ent{ID: 1, Name: "a"}, &ent{ID: 2, Name: "b"}
+	registerEnts(t, db, e1, e2)
+	commitBlock(t, db, ZEROCPT)
+
+	kt2, _ := ReflectKeyTypeM(e2)
+	if err := db.Unregister(kt2); err != nil {
+		t.Fatal(err)
+	}
+	registerEnts(t, db, &ent{ID: 3, Name: "c"})
+	commitBlock(t, db, DELTACPT)
+
+	files := ReadContexts(fs)
+	if len(files) != 2 || files[0].Ctx == nil || files[1].Ctx == nil {
+		t.Fatalf("could not read both contexts: %v %v", files[0].Err, files[1].Err)
+	}
+	ctx := LatestContext(files)
+	if ctx.RCID != 1 || ctx.DCNT != 1 || ctx.MCNT != 2 {
+		t.Fatalf("unexpected latest context: %#v", ctx)
+	}
+
+	snap, err := ReadSnapshot(fs, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := ReflectTypeM(e1)
+	if len(snap.Immutable[typ]) != 2 || len(snap.Mutable[typ]) != 2 {
+		t.Fatalf("unexpected snapshot: %d immutable, %d mutable",
+			len(snap.Immutable[typ]), len(snap.Mutable[typ]))
+	}
+	if len(snap.Deltas) != 1 || snap.Deltas[0].count() != 2 {
+		t.Fatalf("unexpected deltas: %v", snap.Deltas)
+	}
+	if op := snap.Deltas[0].lookup(kt2); op == nil || op.Action != REMOVE {
+		t.Fatalf("remove of %s not in delta", kt2.String())
+	}
+	for _, path := range []string{ctx.ImmPath(), ctx.MutPath(), ctx.DeltaPaths()[0]} {
+		if snap.Sizes[path] == 0 {
+			t.Errorf("size of %s was not recorded", path)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package statedb
+
+// Restore phases reported to Options.Progress
+const (
+	RestoreContext   = "context"   // the most recent context was read
+	RestoreManifest  = "manifest"  // the manifest of a content-addressed checkpoint was read
+	RestoreImmutable = "immutable" // the immutable entries were retrieved
+	RestoreMutable   = "mutable"   // the mutable entries were retrieved
+	RestoreDelta     = "delta"     // a delta checkpoint was retrieved
+	RestoreReplay    = "replay"    // the deltas were replayed
+	RestoreType      = "type"      // a lazily restored type was retrieved
+	RestoreDone      = "done"      // the checkpoint has been fully loaded
+)
+
+// RestoreProgress is reported once a phase of the restore has completed
+type RestoreProgress struct {
+	Phase   string
+	Path    string // the file, or the type for RestoreType
+	Bytes   int    // bytes read during the phase
+	Entries int    // entries decoded during the phase
+}
+
+// Immutable entries of a content-addressed checkpoint that are
+// waiting to be retrieved: type -> key -> chunks
+type lazyTypes map[string]map[Key][]string
+
+func (o *Options) report(phase, path string, bytes, entries int) {
+	if o == nil || o.Progress == nil {
+		return
+	}
+	o.Progress(RestoreProgress{
+		Phase:   phase,
+		Path:    path,
+		Bytes:   bytes,
+		Entries: entries,
+	})
+}
+
+// Loaded blocks until a lazy restore has loaded the checkpoint, and
+// returns the error it failed with. A database whose restore failed
+// refuses every operation and sync with that error, rather than
+// checkpointing over the lineage it was restored from; quit it and
+// open it again.
+func (db *StateDB) Loaded() error {
+	return db.waitLoaded()
+}
+
+// Blocks until a lazy restore has loaded the checkpoint
+func (db *StateDB) waitLoaded() error {
+	if db.loaded != nil {
+		<-db.loaded
+	}
+	return db.loadErr
+}
+
+// Retrieves the immutable entries of the type, if they were deferred
+// by a lazy restore. They are filled in on the stateLoop, which owns
+// the immutable states.
+func (db *StateDB) materialize(typ string) error {
+	if err := db.waitLoaded(); err != nil {
+		return err
+	}
+
+	var err error
+	db.serve(func() {
+		err = db.materializeType(typ)
+	})
+	return err
+}
+
+// Retrieves the deferred entries of the type. Called from stateLoop.
+func (db *StateDB) materializeType(typ string) error {
+	pending, ok := db.lazy[typ]
+	if !ok {
+		return nil
+	}
+
+	bytes, n, err := fetchType(db.fs, db.immutable[typ], pending, make(map[string][]byte))
+	if err != nil {
+		return err
+	}
+	if err := db.opts.migrations().upgradeType(db.immutable[typ]); err != nil {
+		return err
+	}
+	delete(db.lazy, typ)
+	db.opts.report(RestoreType, typ, bytes, n)
+
+	return nil
+}
+
+// Retrieves every deferred type, which is required before a
+// zero checkpoint can be encoded. Called from stateLoop.
+func (db *StateDB) materializeAll() error {
+	for typ := range db.lazy {
+		if err := db.materializeType(typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package statedb
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestLazyRestoreProgress(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, &Options{Dedup: true})
+	registerEnts(t, db, &ent{ID: 1, Name: "a"}, &ent{ID: 2, Name: "b"})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+
+	var mu sync.Mutex
+	phases := map[string]int{}
+	opts := &Options{
+		LazyRestore: true,
+		Progress: func(p RestoreProgress) {
+			mu.Lock()
+			phases[p.Phase] += p.Entries
+			mu.Unlock()
+		},
+	}
+
+	db, restored := openTestDB(t, fs, opts)
+	defer db.Quit()
+	if !restored {
+		t.Fatal("StateDB: did not signal a restore")
+	}
+	if err := db.waitLoaded(); err != nil {
+		t.Fatal(err)
+	}
+
+	typ := ReflectTypeM(&ent{})
+	if _, ok := db.lazy[typ]; !ok {
+		t.Fatal("type was retrieved before it was restored")
+	}
+
+	it, err := db.RestoreIter(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		e := new(ent)
+		if _, ok := it.Next(e); !ok {
+			break
+		}
+		n++
+	}
+	if n != 2 {
+		t.Fatalf("restored %d entries, expected 2", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for phase, exp := range map[string]int{
+		RestoreContext:  1,
+		RestoreManifest: 2,
+		RestoreMutable:  2,
+		RestoreType:     2,
+	} {
+		if phases[phase] != exp {
+			t.Errorf("phase %s reported %d entries, expected %d", phase, phases[phase], exp)
+		}
+	}
+}
+
+func TestLazyRestoreFailed(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db, &ent{ID: 1})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+	ctx, _ := retrieveContext(fs)
+	fs.setFailGet(ctx.MutPath(), true)
+
+	db, restored := openTestDB(t, fs, &Options{LazyRestore: true})
+	defer db.Quit()
+	if !restored {
+		t.Fatal("StateDB: did not signal a restore")
+	}
+	if err := db.Loaded(); err == nil {
+		t.Fatal("the failed load was not reported")
+	}
+
+	// the database neither changes nor checkpoints over the lineage
+	if _, err := db.Register(&ent{ID: 2}); err == nil {
+		t.Fatal("registered a state after a failed load")
+	}
+	if err := db.ForceFullCPT(); err == nil {
+		t.Fatal("checkpointed after a failed load")
+	}
+	if c, _ := retrieveContext(fs); *c != *ctx {
+		t.Fatalf("the context was overwritten: %#v", c)
+	}
+}
+
+func TestLazyRestoreConcurrentRegister(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, &Options{Dedup: true})
+	registerEnts(t, db, &ent{ID: 1}, &ent{ID: 2})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+
+	db, _ = openTestDB(t, fs, &Options{Dedup: true, LazyRestore: true})
+	defer db.Quit()
+	// bound without retrieving the immutable entries
+	kt1, _ := ReflectKeyTypeM(&ent{ID: 1})
+	kt2, _ := ReflectKeyTypeM(&ent{ID: 2})
+	if err := db.AttachAll([]Binding{{kt1, &entMut{}}, {kt2, &entMut{}}}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deferred entries are filled in while states of the type register
+	errs := make(chan error, 1)
+	go func() {
+		for id := 3; id < 50; id++ {
+			if _, err := db.Register(&ent{ID: id}); err != nil {
+				errs <- err
+				return
+			}
+		}
+		errs <- nil
+	}()
+	if _, err := db.RestoreIter(ReflectTypeM(&ent{})); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n := db.Count(ReflectTypeM(&ent{})); n != 49 {
+		t.Fatalf("expected 49 states, found %d", n)
+	}
+}
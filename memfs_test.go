@@ -0,0 +1,99 @@
+package statedb
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"sync"
+)
+
+// in-memory Persistence used by the tests
+type memFS struct {
+	files map[string][]byte
+	fail  map[string]bool   // names that fail on Put
+	gets  map[string]bool   // names that fail on Get
+	puts  []string          // names in the order they were written
+	onPut func(name string) // called after every successful Put
+	sync.Mutex
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		files: make(map[string][]byte),
+		fail:  make(map[string]bool),
+		gets:  make(map[string]bool),
+	}
+}
+
+func (m *memFS) Init() error {
+	return nil
+}
+
+func (m *memFS) Put(name string, data []byte) error {
+	m.Lock()
+	if m.fail[name] {
+		m.Unlock()
+		return errors.New("memFS: put failed " + name)
+	}
+	m.files[name] = append([]byte(nil), data...)
+	m.puts = append(m.puts, name)
+	onPut := m.onPut
+	m.Unlock()
+
+	if onPut != nil {
+		onPut(name)
+	}
+	return nil
+}
+
+func (m *memFS) Get(name string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	if m.gets[name] {
+		return nil, errors.New("memFS: get failed " + name)
+	}
+	data, ok := m.files[name]
+	if !ok {
+		return nil, errors.New("memFS: no such file " + name)
+	}
+	return data, nil
+}
+
+func (m *memFS) Delete(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) List(pattern string) ([]string, error) {
+	m.Lock()
+	defer m.Unlock()
+	items := []string{}
+	for name := range m.files {
+		if ok, _ := path.Match(pattern, name); ok {
+			items = append(items, name)
+		}
+	}
+	sort.Strings(items)
+	return items, nil
+}
+
+func (m *memFS) setFail(name string, fail bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.fail[name] = fail
+}
+
+func (m *memFS) setFailGet(name string, fail bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.gets[name] = fail
+}
+
+func (m *memFS) has(name string) bool {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.files[name]
+	return ok
+}
@@ -0,0 +1,234 @@
+package statedb
+
+import (
+	"fmt"
+)
+
+// Migrations upgrade the entries of a checkpoint written by an older
+// version of the application, so new code can be deployed against old
+// checkpoints:
+//
+//	m := statedb.NewMigrations().
+//		Rename("main.Particle", "sim.Particle").
+//		Upgrade("sim.Particle", 0, particleV1, particleMutV1)
+//
+// Renames are applied first, so upgrades are registered under the new
+// TypeID. Both are applied during a restore, to the immutable, mutable
+// and delta checkpoints alike, and are set through Options.Migrations.
+type Migrations struct {
+	renames  map[string]string
+	upgrades map[string]map[int]*upgrade
+}
+
+// Converts an encoded entry from one version of its type to the next
+type UpgradeFunc func(val []byte) ([]byte, error)
+
+type upgrade struct {
+	imm UpgradeFunc
+	mut UpgradeFunc
+}
+
+func NewMigrations() *Migrations {
+	return &Migrations{
+		renames:  make(map[string]string),
+		upgrades: make(map[string]map[int]*upgrade),
+	}
+}
+
+// Rename restores the entries checkpointed with the TypeID from as
+// entries of the TypeID to.
+func (m *Migrations) Rename(from, to string) *Migrations {
+	m.renames[from] = to
+	return m
+}
+
+// Upgrade registers the functions that convert the immutable and mutable
+// part of an entry of the type from version from to version from+1 (see
+// Versioner). Entries that were checkpointed before the type implemented
+// Versioner are at version 0. Either function may be <nil> if that part
+// is unchanged.
+func (m *Migrations) Upgrade(typeID string, from int, imm, mut UpgradeFunc) *Migrations {
+	if _, ok := m.upgrades[typeID]; !ok {
+		m.upgrades[typeID] = make(map[int]*upgrade)
+	}
+	m.upgrades[typeID][from] = &upgrade{imm: imm, mut: mut}
+	return m
+}
+
+// Follows the renames of the TypeID
+func (m *Migrations) rename(typ string) string {
+	seen := map[string]bool{typ: true}
+	for {
+		to, ok := m.renames[typ]
+		if !ok || seen[to] {
+			return typ
+		}
+		seen[to] = true
+		typ = to
+	}
+}
+
+// Applies every upgrade from version ver, and returns the upgraded
+// value and version
+func (m *Migrations) upgrade(kt *KeyType, val []byte, ver int, mutable bool) ([]byte, int, error) {
+	ups := m.upgrades[kt.T]
+	for {
+		up, ok := ups[ver]
+		if !ok {
+			return val, ver, nil
+		}
+		fn := up.imm
+		if mutable {
+			fn = up.mut
+		}
+		if fn != nil {
+			var err error
+			if val, err = fn(val); err != nil {
+				return nil, ver, fmt.Errorf("StateDB.Migrate: %s from version %d: %s", kt.String(), ver, err.Error())
+			}
+		}
+		ver++
+	}
+}
+
+// Renames and upgrades the immutable entries. Entries without a value
+// are still waiting for a lazy restore, and are upgraded by upgradeType.
+func (m *Migrations) migrateImmutable(imm ImmKeyTypeMap, pending lazyTypes) error {
+	if m == nil {
+		return nil
+	}
+
+	for typ, states := range imm {
+		to := m.rename(typ)
+		if to == typ {
+			continue
+		}
+		delete(imm, typ)
+		if _, ok := imm[to]; !ok {
+			imm[to] = make(ImmStateMap, len(states))
+		}
+		for k, s := range states {
+			s.KT.T = to
+			imm[to][k] = s
+		}
+		if chunks, ok := pending[typ]; ok {
+			delete(pending, typ)
+			pending[to] = chunks
+		}
+	}
+
+	for _, states := range imm {
+		if err := m.upgradeType(states); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Upgrades every entry of a type that has a value
+func (m *Migrations) upgradeType(states ImmStateMap) error {
+	if m == nil {
+		return nil
+	}
+	for _, s := range states {
+		if s.Val == nil {
+			continue
+		}
+		val, ver, err := m.upgrade(&s.KT, s.Val, s.Ver, false)
+		if err != nil {
+			return err
+		}
+		s.Val, s.Ver = val, ver
+	}
+	return nil
+}
+
+func (m *Migrations) migrateMutable(mut MutKeyTypeMap) error {
+	if m == nil {
+		return nil
+	}
+
+	for typ, states := range mut {
+		to := m.rename(typ)
+		if to == typ {
+			continue
+		}
+		delete(mut, typ)
+		if _, ok := mut[to]; !ok {
+			mut[to] = make(MutStateMap, len(states))
+		}
+		for k, s := range states {
+			s.KT.T = to
+			mut[to][k] = s
+		}
+	}
+
+	for _, states := range mut {
+		for _, s := range states {
+			val, ver, err := m.upgrade(&s.KT, s.Val, s.Ver, true)
+			if err != nil {
+				return err
+			}
+			s.Val, s.Ver = val, ver
+		}
+	}
+	return nil
+}
+
+func (m *Migrations) migrateDelta(delta DeltaTypeMap) error {
+	if m == nil {
+		return nil
+	}
+
+	for typ, ops := range delta {
+		to := m.rename(typ)
+		if to == typ {
+			continue
+		}
+		delete(delta, typ)
+		if _, ok := delta[to]; !ok {
+			delta[to] = make(DeltaStateOpMap, len(ops))
+		}
+		for k, op := range ops {
+			op.KT.T = to
+			delta[to][k] = op
+		}
+	}
+
+	for _, ops := range delta {
+		for _, op := range ops {
+			if op.Action == REMOVE {
+				continue
+			}
+			val, ver, err := m.upgrade(&op.KT, op.Val, op.Ver, false)
+			if err != nil {
+				return err
+			}
+			op.Val, op.Ver = val, ver
+		}
+	}
+	return nil
+}
+
+// Renames and upgrades an operation replayed from the WAL
+func (m *Migrations) migrateRecord(r *walRecord) error {
+	if m == nil {
+		return nil
+	}
+
+	r.KT.T = m.rename(r.KT.T)
+	if r.Action == REMOVE {
+		return nil
+	}
+	imm, ver, err := m.upgrade(&r.KT, r.Imm, r.Ver, false)
+	if err != nil {
+		return err
+	}
+	if r.Mut != nil {
+		if r.Mut, _, err = m.upgrade(&r.KT, r.Mut, r.Ver, true); err != nil {
+			return err
+		}
+	}
+	r.Imm, r.Ver = imm, ver
+	return nil
+}
@@ -0,0 +1,158 @@
+package statedb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+type entMutV2 struct {
+	X int
+}
+
+// ent after its Name was renamed to Label, and its Pos to X
+type entV2 struct {
+	ID    int
+	Label string
+	m     entMutV2
+}
+
+func (e *entV2) Mutable() interface{} { return &e.m }
+func (e *entV2) Version() int         { return 1 }
+
+func entUpgrade(val []byte) ([]byte, error) {
+	old := new(ent)
+	if err := Decode(val, old); err != nil {
+		return nil, err
+	}
+	return encode(&entV2{ID: old.ID, Label: old.Name})
+}
+
+func entMutUpgrade(val []byte) ([]byte, error) {
+	old := new(entMut)
+	if err := Decode(val, old); err != nil {
+		return nil, err
+	}
+	return encode(&entMutV2{X: old.Pos})
+}
+
+func entMigrations() *Migrations {
+	return NewMigrations().
+		Rename(ReflectTypeM(&ent{}), "statedb.entOld").
+		Rename("statedb.entOld", ReflectTypeM(&entV2{})).
+		Upgrade(ReflectTypeM(&entV2{}), 0, entUpgrade, entMutUpgrade)
+}
+
+func TestMigrateRestore(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db,
+		&ent{ID: 1, Name: "a", m: entMut{Pos: 10}},
+		&ent{ID: 2, Name: "b", m: entMut{Pos: 20}})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+
+	db, _ = openTestDB(t, fs, &Options{Migrations: entMigrations()})
+	defer db.Quit()
+
+	if _, err := Restore[ent](db); err == nil {
+		t.Fatal("the old type was not renamed")
+	}
+	it, err := Restore[entV2](db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := it.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("restored %d states, expected 2", len(all))
+	}
+	for _, e := range all {
+		if e.Label == "" || e.m.X != e.ID*10 {
+			t.Errorf("state %d was not upgraded: %#v", e.ID, e)
+		}
+	}
+	if !db.Ready() {
+		t.Fatal("not ready after every upgraded state was restored")
+	}
+
+	// new entries are checkpointed with the current version
+	if _, err := db.Register(&entV2{ID: 3, Label: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := restore(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := ReflectTypeM(&entV2{})
+	if len(restored.immutable[typ]) != 3 {
+		t.Fatalf("expected 3 states, got %d", len(restored.immutable[typ]))
+	}
+	for _, s := range restored.immutable[typ] {
+		if s.Ver != 1 {
+			t.Errorf("%s was checkpointed at version %d", s.KT.String(), s.Ver)
+		}
+	}
+	for _, s := range restored.mutable[typ] {
+		if s.Ver != 1 {
+			t.Errorf("mutable %s was checkpointed at version %d", s.KT.String(), s.Ver)
+		}
+	}
+}
+
+func TestMigrateDelta(t *testing.T) {
+	typ := ReflectTypeM(&ent{})
+	kt1, _ := NewIntKeyType(1, typ)
+	kt2, _ := NewIntKeyType(2, typ)
+	val, _ := encode(&ent{ID: 1, Name: "a"})
+
+	delta := make(DeltaTypeMap)
+	delta.insert(kt1, val, 0)
+	delta.remove(kt2)
+
+	if err := entMigrations().migrateDelta(delta); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := delta[typ]; ok {
+		t.Fatal("the old type was not renamed")
+	}
+	ops := delta[ReflectTypeM(&entV2{})]
+	if len(ops) != 2 {
+		t.Fatalf("expected 2 renamed operations, got %d", len(ops))
+	}
+	ins := ops[kt1.K]
+	e := new(entV2)
+	if err := Decode(ins.Val, e); err != nil {
+		t.Fatal(err)
+	}
+	if ins.Ver != 1 || e.Label != "a" {
+		t.Fatalf("insert was not upgraded: %d %#v", ins.Ver, e)
+	}
+	if ops[kt2.K].KT.T != ReflectTypeM(&entV2{}) {
+		t.Fatal("the KeyType of the remove was not renamed")
+	}
+}
+
+// Mutable states checkpointed before versioning decode at version 0
+func TestMutStateUnversioned(t *testing.T) {
+	kt, _ := NewIntKeyType(1, "t")
+	var buff bytes.Buffer
+	enc := gob.NewEncoder(&buff)
+	enc.Encode(*kt)
+	enc.Encode([]byte("val"))
+
+	ms := new(MutState)
+	if err := ms.GobDecode(buff.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if ms.Ver != 0 || string(ms.Val) != "val" {
+		t.Fatalf("unexpected mutable state: %#v", ms)
+	}
+}
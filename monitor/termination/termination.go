@@ -0,0 +1,153 @@
+// Package termination watches the instance metadata of EC2 for the
+// two-minute termination notice of a spot instance.
+package termination
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// the instance metadata service of EC2
+	DefaultEndpoint = "http://169.254.169.254"
+	// polling interval, the notice is posted two minutes ahead
+	DefaultInterval = 5 * time.Second
+
+	tokenPath  = "/latest/api/token"
+	actionPath = "/latest/meta-data/spot/instance-action"
+	tokenTTL   = 6 * time.Hour
+	// a token is renewed this long before it expires
+	tokenSlack = time.Minute
+)
+
+// MetadataWatcher polls the instance metadata for a termination
+// notice. It satisfies statedb.TerminationWatcher.
+type MetadataWatcher struct {
+	Endpoint string
+	Interval time.Duration
+	Client   *http.Client
+
+	mu      sync.Mutex
+	token   string // session token of IMDSv2
+	expires time.Time
+}
+
+func NewMetadataWatcher() *MetadataWatcher {
+	return &MetadataWatcher{
+		Endpoint: DefaultEndpoint,
+		Interval: DefaultInterval,
+		Client:   &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+// the body of spot/instance-action
+type action struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// Watch delivers the time of the termination once a notice of
+// a stop or termination is posted, and closes the channel
+// afterwards or once stop is closed.
+func (w *MetadataWatcher) Watch(stop <-chan bool) <-chan time.Time {
+	notices := make(chan time.Time, 1)
+	go func() {
+		defer close(notices)
+		tick := time.NewTicker(w.Interval)
+		defer tick.Stop()
+		// reported once, rather than on every poll off EC2
+		failing := false
+		for {
+			at, ok, err := w.poll()
+			if err != nil && !failing {
+				fmt.Println("termination: polling the instance metadata failed:", err)
+			} else if err == nil && failing {
+				fmt.Println("termination: polling the instance metadata recovered")
+			}
+			failing = err != nil
+			if ok {
+				notices <- at
+				return
+			}
+			select {
+			case <-tick.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return notices
+}
+
+// Returns the time of the termination, if a notice was posted
+func (w *MetadataWatcher) poll() (time.Time, bool, error) {
+	req, err := http.NewRequest("GET", w.Endpoint+actionPath, nil)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	// IMDSv2, or IMDSv1 without a token
+	if token, err := w.sessionToken(); err == nil {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		// the token expired early, or IMDSv2 became required
+		w.mu.Lock()
+		w.token = ""
+		w.mu.Unlock()
+		return time.Time{}, false, fmt.Errorf("%s: %s", actionPath, resp.Status)
+	case http.StatusNotFound:
+		// no notice posted
+		return time.Time{}, false, nil
+	default:
+		return time.Time{}, false, fmt.Errorf("%s: %s", actionPath, resp.Status)
+	}
+
+	var a action
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return time.Time{}, false, err
+	}
+	// hibernations are handled like terminations
+	return a.Time, true, nil
+}
+
+// Returns the session token of IMDSv2, which is
+// requested again shortly before it expires
+func (w *MetadataWatcher) sessionToken() (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.token != "" && time.Now().Before(w.expires) {
+		return w.token, nil
+	}
+	req, err := http.NewRequest("PUT", w.Endpoint+tokenPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", fmt.Sprint(int(tokenTTL.Seconds())))
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", tokenPath, resp.Status)
+	}
+	token, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	w.token = string(token)
+	w.expires = time.Now().Add(tokenTTL - tokenSlack)
+	return w.token, nil
+}
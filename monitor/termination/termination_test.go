@@ -0,0 +1,115 @@
+package termination
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paddie/statedb"
+)
+
+var _ statedb.TerminationWatcher = (*MetadataWatcher)(nil)
+
+// Fakes the instance metadata service, posting
+// the notice after the given number of polls
+type fakeMetadata struct {
+	sync.Mutex
+	polls, after int
+	issued       int  // session tokens
+	tokens       bool // require IMDSv2
+	at           time.Time
+}
+
+func (f *fakeMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.Method == "PUT" && r.URL.Path == tokenPath:
+		if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "missing ttl", http.StatusBadRequest)
+			return
+		}
+		f.issued++
+		fmt.Fprint(w, "token")
+	case r.Method == "GET" && r.URL.Path == actionPath:
+		if f.tokens && r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		f.polls++
+		if f.polls <= f.after {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"action": "terminate", "time": "%s"}`, f.at.Format(time.RFC3339))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newWatcher(f *fakeMetadata) (*MetadataWatcher, func()) {
+	srv := httptest.NewServer(f)
+	w := NewMetadataWatcher()
+	w.Endpoint = srv.URL
+	w.Interval = time.Millisecond
+	return w, srv.Close
+}
+
+func TestWatch(t *testing.T) {
+	at := time.Now().Add(2 * time.Minute).UTC().Truncate(time.Second)
+	f := &fakeMetadata{after: 3, tokens: true, at: at}
+	w, done := newWatcher(f)
+	defer done()
+
+	stop := make(chan bool)
+	defer close(stop)
+	select {
+	case got := <-w.Watch(stop):
+		if !got.Equal(at) {
+			t.Fatalf("expected the termination at %s, got %s", at, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the notice was not delivered")
+	}
+	f.Lock()
+	polls, issued := f.polls, f.issued
+	f.Unlock()
+	if polls != 4 {
+		t.Fatalf("expected 4 polls, got %d", polls)
+	}
+	// the session token is reused until it expires
+	if issued != 1 {
+		t.Fatalf("expected a single session token, got %d", issued)
+	}
+
+	w.mu.Lock()
+	w.expires = time.Now()
+	w.mu.Unlock()
+	w.poll()
+	f.Lock()
+	defer f.Unlock()
+	if f.issued != 2 {
+		t.Fatalf("the expired session token was not renewed: %d", f.issued)
+	}
+}
+
+func TestWatchStop(t *testing.T) {
+	f := &fakeMetadata{after: 1 << 30}
+	w, done := newWatcher(f)
+	defer done()
+
+	stop := make(chan bool)
+	notices := w.Watch(stop)
+	close(stop)
+	select {
+	case _, ok := <-notices:
+		if ok {
+			t.Fatal("a notice was delivered")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watcher did not stop")
+	}
+}
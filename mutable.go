@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"reflect"
 )
 
@@ -17,7 +18,11 @@ type MutStateMap map[Key]*MutState
 type MutState struct {
 	KT  KeyType
 	Val []byte
+	Ver int           // see Versioner
 	v   reflect.Value // pointer to the latest update
+	// checkpoint Val as it is, for tools that rewrite
+	// checkpoints without the live pointers
+	frozen bool
 }
 
 func (m MutKeyTypeMap) count() int {
@@ -37,26 +42,31 @@ func (m MutKeyTypeMap) count() int {
 // followed by a normal encoding of the struct
 func (m *MutState) GobEncode() ([]byte, error) {
 
-	if !m.v.IsValid() {
-		return nil, fmt.Errorf("Trying to checkpoint a mutable state with a pointer from a previous ceckpoint")
-	}
+	if !m.frozen {
+		if !m.v.IsValid() {
+			return nil, fmt.Errorf("Trying to checkpoint a mutable state with a pointer from a previous ceckpoint")
+		}
 
-	var b bytes.Buffer
-	enc := gob.NewEncoder(&b)
-	if err := enc.EncodeValue(m.v.Elem()); err != nil {
-		return nil, err
+		var b bytes.Buffer
+		enc := gob.NewEncoder(&b)
+		if err := enc.EncodeValue(m.v.Elem()); err != nil {
+			return nil, err
+		}
+		m.Val = b.Bytes()
 	}
-	m.Val = b.Bytes()
 
 	var buff bytes.Buffer
 
-	enc = gob.NewEncoder(&buff)
+	enc := gob.NewEncoder(&buff)
 	if err := enc.Encode(m.KT); err != nil {
 		return nil, err
 	}
 	if err := enc.Encode(m.Val); err != nil {
 		return nil, err
 	}
+	if err := enc.Encode(m.Ver); err != nil {
+		return nil, err
+	}
 
 	return buff.Bytes(), nil
 }
@@ -72,6 +82,10 @@ func (m *MutState) GobDecode(b []byte) error {
 	if err := enc.Decode(&m.Val); err != nil {
 		return err
 	}
+	// checkpoints written before versioning end here
+	if err := enc.Decode(&m.Ver); err != nil && err != io.EOF {
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,126 @@
+package statedb
+
+import (
+	"time"
+)
+
+// Options configure a StateDB. The zero value (or <nil>) gives the
+// default behaviour of NewStateDB.
+type Options struct {
+	// Store the immutable entries of a zero checkpoint content-addressed,
+	// so only the entries that changed since the previous reference
+	// checkpoint are written. See dedup.go
+	Dedup bool
+	// Size in bytes of the chunks an immutable entry is split into
+	// when Dedup is enabled. Defaults to DefaultChunkSize
+	ChunkSize int
+	// Called every time a phase of the restore completes
+	Progress func(RestoreProgress)
+	// Return from NewStateDB as soon as the context has been read and
+	// load the checkpoint in the background. RestoreIter and RestoreSingle
+	// block until it is loaded, and the entries of a content-addressed
+	// checkpoint are only retrieved once their type is restored. Only
+	// content-addressed checkpoints (Dedup) defer any retrieval; any other
+	// checkpoint is loaded in full, just in the background. A failed load
+	// is reported by Loaded, and by every subsequent operation and sync.
+	LazyRestore bool
+	// What to do with the restored mutable states that have not been
+	// reattached to a pointer at the first point of consistency.
+	// One of UnrestoredRefuse (default), UnrestoredDrop, UnrestoredFail
+	// or UnrestoredBlock
+	UnrestoredPolicy int
+	// How long, from NewStateDB, UnrestoredBlock blocks a sync.
+	// Defaults to DefaultRestoreTimeout
+	RestoreTimeout time.Duration
+	// Renames and upgrades applied to the entries of older
+	// checkpoints during a restore. See NewMigrations
+	Migrations *Migrations
+	// Log every Register and Unregister to this Persistence before it
+	// returns, so the operations since the most recent checkpoint are
+	// replayed by a restore. It can be the Persistence of the checkpoints
+	// or a local one. See wal.go
+	WAL Persistence
+	// How often a Follower checks for a new context, unless its
+	// Persistence is a Watcher. Defaults to DefaultPollInterval
+	PollInterval time.Duration
+	// Take part in the global checkpoints of a Coordinator instead of
+	// checkpointing on the Model, see coordinate.go. The database
+	// restores the global checkpoint GID, or nothing if GID is 0, and
+	// discards the operations of the WAL logged after it.
+	Coordinated bool
+	// The global checkpoint a Coordinated database restores,
+	// see GlobalCheckpoint
+	GID int
+	// Take over the checkpoints with a fencing token stored in the
+	// Persistence, so an earlier instance of the job that still runs
+	// stops checkpointing with LostOwnershipError. See fence.go
+	Fencing bool
+	// Identifies the instance in the lease.
+	// Defaults to the hostname and process id
+	Owner string
+}
+
+func (o *Options) migrations() *Migrations {
+	if o == nil {
+		return nil
+	}
+	return o.Migrations
+}
+
+func (o *Options) unrestoredPolicy() int {
+	if o == nil {
+		return UnrestoredRefuse
+	}
+	return o.UnrestoredPolicy
+}
+
+func (o *Options) restoreTimeout() time.Duration {
+	if o == nil || o.RestoreTimeout <= 0 {
+		return DefaultRestoreTimeout
+	}
+	return o.RestoreTimeout
+}
+
+func (o *Options) chunkSize() int {
+	if o == nil || o.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return o.ChunkSize
+}
+
+func (o *Options) lazy() bool {
+	return o != nil && o.LazyRestore
+}
+
+func (o *Options) dedup() bool {
+	return o != nil && o.Dedup
+}
+
+func (o *Options) wal() Persistence {
+	if o == nil {
+		return nil
+	}
+	return o.WAL
+}
+
+func (o *Options) pollInterval() time.Duration {
+	if o == nil || o.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return o.PollInterval
+}
+
+func (o *Options) coordinated() bool {
+	return o != nil && o.Coordinated
+}
+
+func (o *Options) fencing() bool {
+	return o != nil && o.Fencing
+}
+
+func (o *Options) owner() string {
+	if o == nil || o.Owner == "" {
+		return defaultOwner()
+	}
+	return o.Owner
+}
@@ -0,0 +1,128 @@
+package statedb
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Entry is a registered state as it was last encoded
+type Entry struct {
+	KT        KeyType
+	Ver       int    // see Versioner
+	Immutable []byte // the encoded immutable state
+	Mutable   []byte // the mutable state as encoded by the most recent checkpoint, <nil> before it
+}
+
+// The queries are served by the stateLoop, so they are safe to call
+// while other goroutines register and unregister states.
+
+// Types returns every type with a registered state, sorted
+func (db *StateDB) Types() []string {
+	if err := db.waitLoaded(); err != nil {
+		return nil
+	}
+	var ts []string
+	db.serve(func() {
+		for t, states := range db.immutable {
+			if len(states) > 0 {
+				ts = append(ts, t)
+			}
+		}
+	})
+	sort.Strings(ts)
+	return ts
+}
+
+// Contains returns true if a state with the KeyType is registered
+func (db *StateDB) Contains(kt *KeyType) bool {
+	if err := db.waitLoaded(); err != nil {
+		return false
+	}
+	ok := false
+	db.serve(func() {
+		ok = db.immutable.contains(kt)
+	})
+	return ok
+}
+
+// Count returns the number of registered states of the type
+func (db *StateDB) Count(typeID string) int {
+	if err := db.waitLoaded(); err != nil {
+		return 0
+	}
+	n := 0
+	db.serve(func() {
+		n = len(db.immutable[typeID])
+	})
+	return n
+}
+
+// Keys returns up to limit keys of the registered states of the type,
+// starting at offset. The keys are ordered by their integer ID, followed
+// by the string IDs in lexical order, so consecutive pages do not overlap
+// unless states are registered or unregistered in between. A limit <= 0
+// returns every key from offset on.
+func (db *StateDB) Keys(typeID string, offset, limit int) []Key {
+	if err := db.waitLoaded(); err != nil {
+		return nil
+	}
+	var keys []Key
+	db.serve(func() {
+		keys = make([]Key, 0, len(db.immutable[typeID]))
+		for k := range db.immutable[typeID] {
+			keys = append(keys, k)
+		}
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return lessKey(&keys[i], &keys[j])
+	})
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(keys) {
+		return []Key{}
+	}
+	keys = keys[offset:]
+	if limit > 0 && limit < len(keys) {
+		keys = keys[:limit]
+	}
+	return keys
+}
+
+// Get returns a copy of the registered state with the KeyType
+func (db *StateDB) Get(kt *KeyType) (*Entry, error) {
+	if err := db.materialize(kt.TypeID()); err != nil {
+		return nil, err
+	}
+	var e *Entry
+	db.serve(func() {
+		imm := db.immutable.lookup(kt)
+		if imm == nil {
+			return
+		}
+		e = &Entry{
+			KT:        *kt,
+			Ver:       imm.Ver,
+			Immutable: append([]byte(nil), imm.Val...),
+		}
+		if ms := db.mutable.lookup(kt); ms != nil && ms.Val != nil {
+			e.Mutable = append([]byte(nil), ms.Val...)
+		}
+	})
+	if e == nil {
+		return nil, fmt.Errorf("StateDB.Get: KeyType %s does not exist", kt.String())
+	}
+	return e, nil
+}
+
+// Integer keys first, then string keys
+func lessKey(a, b *Key) bool {
+	if a.IntID != b.IntID {
+		if a.IntID == 0 || b.IntID == 0 {
+			return b.IntID == 0
+		}
+		return a.IntID < b.IntID
+	}
+	return a.StringID < b.StringID
+}
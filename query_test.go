@@ -0,0 +1,98 @@
+package statedb
+
+import (
+	"sync"
+	"testing"
+)
+
+type named struct {
+	Name string
+}
+
+func (n *named) Key() string { return n.Name }
+
+func TestQuery(t *testing.T) {
+	db, _ := openTestDB(t, newMemFS(), nil)
+	defer db.Quit()
+
+	typ := ReflectTypeM(&ent{})
+	for id := 1; id <= 12; id++ {
+		registerEnts(t, db, &ent{ID: id, Name: "e", m: entMut{Pos: id}})
+	}
+	for _, name := range []string{"b", "a"} {
+		if _, err := db.Register(&named{name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if types := db.Types(); len(types) != 2 || types[0] != typ || types[1] != ReflectTypeM(&named{}) {
+		t.Fatalf("unexpected types %v", types)
+	}
+	if n := db.Count(typ); n != 12 {
+		t.Fatalf("expected 12 states, got %d", n)
+	}
+
+	// the pages are in order and do not overlap
+	seen := []int{}
+	for offset := 0; ; offset += 5 {
+		keys := db.Keys(typ, offset, 5)
+		if len(keys) == 0 {
+			break
+		}
+		for _, k := range keys {
+			seen = append(seen, k.IntID)
+		}
+	}
+	if len(seen) != 12 || seen[0] != 1 || seen[9] != 10 || seen[11] != 12 {
+		t.Fatalf("unexpected keys %v", seen)
+	}
+	if keys := db.Keys(ReflectTypeM(&named{}), 0, 0); len(keys) != 2 || keys[0].StringID != "a" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+
+	kt, _ := NewIntKeyType(3, typ)
+	if !db.Contains(kt) {
+		t.Fatalf("%s is not registered", kt)
+	}
+	e, err := db.Get(kt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Immutable == nil || e.Mutable != nil {
+		t.Fatalf("unexpected entry before a checkpoint: %+v", e)
+	}
+	commitBlock(t, db, ZEROCPT)
+	if e, _ = db.Get(kt); e.Mutable == nil {
+		t.Fatal("the mutable state was not encoded by the checkpoint")
+	}
+	var m entMut
+	if err := Decode(e.Mutable, &m); err != nil || m.Pos != 3 {
+		t.Fatalf("unexpected mutable state %+v: %v", m, err)
+	}
+
+	// queries are safe while states are registered
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for id := 13; id <= 50; id++ {
+			registerEnts(t, db, &ent{ID: id})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		db.Count(typ)
+		db.Keys(typ, 0, 10)
+		db.Types()
+	}
+	wg.Wait()
+
+	if err := db.Unregister(kt); err != nil {
+		t.Fatal(err)
+	}
+	if db.Contains(kt) {
+		t.Fatalf("%s is still registered", kt)
+	}
+	if _, err := db.Get(kt); err == nil {
+		t.Fatalf("got the unregistered %s", kt)
+	}
+}
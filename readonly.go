@@ -0,0 +1,77 @@
+package statedb
+
+import (
+	"errors"
+)
+
+var (
+	ReadOnlyError = errors.New("The database was opened read-only")
+)
+
+// Persistence that refuses every write
+type readOnlyFS struct {
+	Persistence
+}
+
+func (r readOnlyFS) Init() error {
+	return nil
+}
+
+func (r readOnlyFS) Put(name string, data []byte) error {
+	return ReadOnlyError
+}
+
+func (r readOnlyFS) Delete(path string) error {
+	return ReadOnlyError
+}
+
+// OpenReadOnly restores the most recent checkpoint of fs for analysis.
+// Unlike NewStateDB it needs no Model or Monitor and starts no goroutines:
+// the states are read through RestoreIter, RestoreSingle, Restore, Types
+// and the query methods, which run on the calling goroutine. Nothing is
+// ever written to fs, and every method that would modify the database or
+// checkpoint it returns ReadOnlyError.
+//
+// With Options.LazyRestore, the immutable entries of a content-addressed
+// checkpoint are retrieved the first time their type is restored.
+func OpenReadOnly(fs Persistence, opts *Options) (*StateDB, error) {
+	ctx, err := retrieveContext(fs)
+	if err != nil {
+		return nil, NoCheckpointError
+	}
+	return openReadOnly(fs, ctx, opts)
+}
+
+// OpenReadOnlyAt is OpenReadOnly for the checkpoint with the id RCID.MCNT
+// (see Context.ID), which can be any checkpoint whose files are still in fs.
+func OpenReadOnlyAt(fs Persistence, id string, opts *Options) (*StateDB, error) {
+	ctx, err := resolveContext(fs, id)
+	if err != nil {
+		return nil, err
+	}
+	return openReadOnly(fs, ctx, opts)
+}
+
+func openReadOnly(fs Persistence, ctx *Context, opts *Options) (*StateDB, error) {
+	opts.report(RestoreContext, ctx.CtxPath(), 0, 1)
+
+	db := &StateDB{
+		fs:       readOnlyFS{fs},
+		opts:     opts,
+		readOnly: true,
+		mutable:  make(MutKeyTypeMap),
+		delta:    make(DeltaTypeMap),
+	}
+	if err := db.load(db.fs, ctx, opts.lazy()); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Returns ReadOnlyError if the database was opened read-only
+func (db *StateDB) writable() error {
+	if db.readOnly {
+		return ReadOnlyError
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package statedb
+
+import (
+	"testing"
+)
+
+func TestOpenReadOnly(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		fs := deltaChain(t, &Options{Dedup: dedup})
+		puts := len(fs.puts)
+
+		db, err := OpenReadOnly(fs, &Options{LazyRestore: dedup})
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ := ReflectTypeM(&ent{})
+		if n := db.Count(typ); n != 2 {
+			t.Fatalf("expected 2 states, got %d", n)
+		}
+		it, err := Restore[ent](db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := map[int]int{}
+		for it.Next() {
+			pos[it.Value().ID] = it.Value().m.Pos
+		}
+		if it.Err() != nil {
+			t.Fatal(it.Err())
+		}
+		if len(pos) != 2 || pos[1] != 10 || pos[3] != 3 {
+			t.Fatalf("unexpected states: %v", pos)
+		}
+		if !db.Ready() {
+			t.Fatalf("unrestored states: %v", db.Unrestored())
+		}
+
+		if _, err := db.Register(&ent{ID: 4}); err != ReadOnlyError {
+			t.Fatalf("expected ReadOnlyError, got %v", err)
+		}
+		kt, _ := NewIntKeyType(1, typ)
+		if err := db.Unregister(kt); err != ReadOnlyError {
+			t.Fatalf("expected ReadOnlyError, got %v", err)
+		}
+		for _, fn := range []func() error{db.PointOfConsistency, db.ForceCheckpoint, db.ForceFullCPT, db.FinalCommit} {
+			if err := fn(); err != ReadOnlyError {
+				t.Fatalf("expected ReadOnlyError, got %v", err)
+			}
+		}
+		if err := db.Quit(); err != nil {
+			t.Fatal(err)
+		}
+		if len(fs.puts) != puts {
+			t.Fatalf("read-only database wrote %v", fs.puts[puts:])
+		}
+	}
+}
+
+func TestOpenReadOnlyAt(t *testing.T) {
+	fs := deltaChain(t, nil)
+
+	db, err := OpenReadOnlyAt(fs, "1.1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := Restore[ent](db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := it.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := map[int]int{}
+	for _, e := range all {
+		pos[e.ID] = e.m.Pos
+	}
+	if len(pos) != 2 || pos[1] != 1 || pos[2] != 0 {
+		t.Fatalf("unexpected states at 1.1: %v", pos)
+	}
+
+	if _, err := OpenReadOnlyAt(fs, "1.9", nil); err == nil {
+		t.Fatal("opened a checkpoint that does not exist")
+	}
+}
@@ -0,0 +1,193 @@
+package statedb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What to do with the mutable states that have not been reattached to a
+// live pointer (through RestoreIter, RestoreSingle or Restore) when the
+// first point of consistency after a restore is reached.
+const (
+	// Refuse every sync with NotRestoredError until all of them are restored
+	UnrestoredRefuse = iota
+	// Unregister the states that have not been restored
+	UnrestoredDrop
+	// Return an *UnrestoredError from every subsequent sync
+	UnrestoredFail
+	// Block the sync until all of them are restored, or until
+	// Options.RestoreTimeout has passed since the database was opened
+	UnrestoredBlock
+)
+
+const (
+	// how long a sync blocks under the UnrestoredBlock
+	// policy if Options.RestoreTimeout is not set
+	DefaultRestoreTimeout = time.Minute
+	// interval at which a blocked sync checks for readiness
+	restorePoll = 10 * time.Millisecond
+)
+
+// A function that is run on the stateLoop, where it
+// can safely read the state databases
+type query struct {
+	fn   func()
+	done chan bool
+}
+
+// Runs fn on the stateLoop and waits for it to return. Without a
+// stateLoop, in a read-only database or once it has stopped, nothing
+// else modifies the states and fn runs on the caller.
+func (db *StateDB) serve(fn func()) {
+	if db.query_chan == nil {
+		fn()
+		return
+	}
+	q := &query{
+		fn:   fn,
+		done: make(chan bool),
+	}
+	select {
+	case db.query_chan <- q:
+		<-q.done
+	case <-db.stopped:
+		fn()
+	}
+}
+
+// UnrestoredError lists the KeyTypes still awaiting a pointer
+type UnrestoredError struct {
+	KeyTypes []*KeyType
+}
+
+func (e *UnrestoredError) Error() string {
+	kts := make([]string, 0, len(e.KeyTypes))
+	for _, kt := range e.KeyTypes {
+		kts = append(kts, kt.String())
+	}
+	return fmt.Sprintf("%s: %d unrestored states: %s",
+		NotRestoredError.Error(), len(kts), strings.Join(kts, ", "))
+}
+
+// Is reports UnrestoredError as a NotRestoredError
+func (e *UnrestoredError) Is(target error) bool {
+	return target == NotRestoredError
+}
+
+// Ready returns true once every restored mutable state
+// has been reattached to a live pointer
+func (db *StateDB) Ready() bool {
+	ready := false
+	db.serve(func() {
+		ready = db.readyErr == nil && len(db.unrestored()) == 0
+	})
+	return ready
+}
+
+// Unrestored returns the KeyTypes of the restored mutable states
+// that have not yet been reattached to a live pointer
+func (db *StateDB) Unrestored() []*KeyType {
+	var kts []*KeyType
+	db.serve(func() {
+		kts = db.unrestored()
+	})
+	return kts
+}
+
+// called from stateLoop
+func (db *StateDB) unrestored() []*KeyType {
+
+	if !db.restored {
+		return nil
+	}
+
+	db.RLock()
+	defer db.RUnlock()
+
+	kts := []*KeyType{}
+	for _, vt := range db.mutable {
+		for _, vs := range vt {
+			if !vs.v.IsValid() {
+				kt := vs.KT
+				kts = append(kts, &kt)
+			}
+		}
+	}
+
+	sort.Slice(kts, func(i, j int) bool {
+		return kts[i].String() < kts[j].String()
+	})
+	return kts
+}
+
+// Binds a restored mutable state to a live pointer
+func (db *StateDB) bind(ms *MutState, v reflect.Value) {
+	if db != nil {
+		db.Lock()
+		defer db.Unlock()
+	}
+	ms.v = v
+}
+
+// Applies the unrestored policy at a point of consistency and returns
+// nil if the sync can proceed. Called from stateLoop.
+func (db *StateDB) checkReady(stat *Stat) error {
+
+	if db.readyErr != nil {
+		return db.readyErr
+	}
+
+	kts := db.unrestored()
+	if len(kts) == 0 {
+		db.ready = true
+		return nil
+	}
+
+	switch db.opts.unrestoredPolicy() {
+	case UnrestoredDrop:
+		for _, kt := range kts {
+			fmt.Println("StateDB: dropping unrestored state", kt.String())
+			if err := db.remove(kt); err != nil {
+				return err
+			}
+			db.publish(EventRemoved, kt, nil, nil)
+			stat.remove(1, 1)
+		}
+		db.ready = true
+		return nil
+	case UnrestoredFail:
+		db.readyErr = &UnrestoredError{KeyTypes: kts}
+		return db.readyErr
+	}
+	return db.unrestoredError(kts)
+}
+
+func (db *StateDB) unrestoredError(kts []*KeyType) error {
+	if db.opts.unrestoredPolicy() == UnrestoredRefuse {
+		return NotRestoredError
+	}
+	return &UnrestoredError{KeyTypes: kts}
+}
+
+// Returns true if a sync refused by checkReady should be retried
+func (db *StateDB) blockSync() bool {
+	return db.readyErr == nil &&
+		db.opts.unrestoredPolicy() == UnrestoredBlock &&
+		time.Since(db.opened) < db.opts.restoreTimeout()
+}
+
+// Resends the sync once every state has been restored or the
+// restore timeout has passed, leaving the stateLoop free to
+// serve the restores in the meantime.
+func (db *StateDB) awaitReady(m *msg) {
+	deadline := db.opened.Add(db.opts.restoreTimeout())
+	for time.Now().Before(deadline) && !db.Ready() {
+		time.Sleep(restorePoll)
+	}
+	if err := db.sendSync(m); err != nil {
+		m.err <- err
+	}
+}
@@ -0,0 +1,154 @@
+package statedb
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Checkpoints two entities and reopens the database
+func reopenUnrestored(t *testing.T, opts *Options) (*StateDB, Persistence) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db,
+		&ent{ID: 1, Name: "a", m: entMut{Pos: 10}},
+		&ent{ID: 2, Name: "b", m: entMut{Pos: 20}})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+
+	db, restored := openTestDB(t, fs, opts)
+	if !restored {
+		t.Fatal("StateDB: did not signal a restore")
+	}
+	return db, fs
+}
+
+// Restores only the entity with the ID
+func restoreOne(t *testing.T, db *StateDB, id int) {
+	entries, err := db.entries(ReflectTypeM(&ent{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.kt.IntID() == id {
+			if err := e.restore(new(ent)); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no entity with ID %d", id)
+}
+
+func TestUnrestoredReport(t *testing.T) {
+	db, _ := reopenUnrestored(t, nil)
+	defer db.Quit()
+
+	if db.Ready() {
+		t.Fatal("ready before any state was restored")
+	}
+	restoreOne(t, db, 1)
+
+	kts := db.Unrestored()
+	if len(kts) != 1 || kts[0].IntID() != 2 {
+		t.Fatalf("unexpected unrestored states: %v", kts)
+	}
+	if err := db.ForceCheckpoint(); err != NotRestoredError {
+		t.Fatalf("expected NotRestoredError, got %v", err)
+	}
+	// does not block the stateLoop
+	if _, err := db.Register(&ent{ID: 3}); err != NotRestoredError {
+		t.Fatalf("expected NotRestoredError, got %v", err)
+	}
+
+	restoreOne(t, db, 2)
+	if !db.Ready() || len(db.Unrestored()) != 0 {
+		t.Fatal("not ready after every state was restored")
+	}
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnrestoredDrop(t *testing.T) {
+	db, fs := reopenUnrestored(t, &Options{UnrestoredPolicy: UnrestoredDrop})
+	restoreOne(t, db, 1)
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+
+	restored, err := restore(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := Restore[ent](restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := it.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].ID != 1 {
+		t.Fatalf("unrestored state was not dropped: %v", all)
+	}
+}
+
+func TestUnrestoredFail(t *testing.T) {
+	db, _ := reopenUnrestored(t, &Options{UnrestoredPolicy: UnrestoredFail})
+	defer db.Quit()
+
+	restoreOne(t, db, 1)
+	err := db.ForceCheckpoint()
+	var uerr *UnrestoredError
+	if !errors.As(err, &uerr) || len(uerr.KeyTypes) != 1 {
+		t.Fatalf("expected an UnrestoredError, got %v", err)
+	}
+	if !errors.Is(err, NotRestoredError) {
+		t.Fatal("UnrestoredError is not a NotRestoredError")
+	}
+
+	// the failure is permanent
+	restoreOne(t, db, 2)
+	if err := db.ForceCheckpoint(); !errors.As(err, &uerr) {
+		t.Fatalf("expected an UnrestoredError, got %v", err)
+	}
+}
+
+func TestUnrestoredBlock(t *testing.T) {
+	db, _ := reopenUnrestored(t, &Options{UnrestoredPolicy: UnrestoredBlock})
+	defer db.Quit()
+
+	restoreOne(t, db, 1)
+	errc := make(chan error)
+	go func() {
+		errc <- db.forceZeroCPTBlock()
+	}()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("sync returned before every state was restored: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	restoreOne(t, db, 2)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnrestoredBlockTimeout(t *testing.T) {
+	db, _ := reopenUnrestored(t, &Options{
+		UnrestoredPolicy: UnrestoredBlock,
+		RestoreTimeout:   50 * time.Millisecond,
+	})
+	defer db.Quit()
+
+	var uerr *UnrestoredError
+	if err := db.ForceCheckpoint(); !errors.As(err, &uerr) || len(uerr.KeyTypes) != 2 {
+		t.Fatalf("expected an UnrestoredError, got %v", err)
+	}
+}
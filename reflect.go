@@ -22,6 +22,14 @@ type Typer interface {
 	Type() string
 }
 
+// Versioner is implemented by types whose encoding has changed
+// over time. The version is stored with every checkpointed entry,
+// and the upgrades registered in the Migrations of the Options
+// bring older entries up to date during a restore.
+type Versioner interface {
+	Version() int
+}
+
 func ReflectKeyTypeM(v interface{}) (*KeyType, error) {
 
 	if v == nil {
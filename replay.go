@@ -10,6 +10,7 @@ import (
 	// "log"
 	// "os"
 	// "reflect"
+	"sort"
 	// "sync"
 )
 
@@ -17,33 +18,94 @@ var (
 	NoCheckpointError = errors.New("No Previous Checkpoint")
 )
 
-func restore(fs Persistence) (*StateDB, error) {
+func restore(fs Persistence, opts *Options) (*StateDB, error) {
 
 	// retrieve the most recent context
-	ctx, err := retrieveContext(fs)
+	ctx, err := restoreContext(fs, opts)
 	if err != nil {
-		// No previous checkpoint was registered
-		return nil, NoCheckpointError
+		return nil, err
 	}
+	opts.report(RestoreContext, ctx.CtxPath(), 0, 1)
 
 	db := &StateDB{
-		ctx: ctx,
+		ctx:  ctx,
+		fs:   fs,
+		opts: opts,
 		// op_chan: make(chan *StateOperation),
 		// quit:    make(chan chan error),
 	}
 
-	imm, err := retrieveImmutable(fs, ctx)
+	if err := db.load(fs, ctx, false); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Reads the most recent context and returns without loading
+// the checkpoint, which is left to loadLazy
+func restoreLazy(fs Persistence, opts *Options) (*StateDB, error) {
+
+	ctx, err := restoreContext(fs, opts)
 	if err != nil {
 		return nil, err
 	}
-	db.immutable = imm
+	opts.report(RestoreContext, ctx.CtxPath(), 0, 1)
+
+	db := &StateDB{
+		ctx:      ctx,
+		fs:       fs,
+		opts:     opts,
+		restored: true,
+		loaded:   make(chan bool),
+	}
+
+	return db, nil
+}
+
+// Loads the checkpoint of a lazy restore in the background
+func (db *StateDB) loadLazy() {
+	err := db.load(db.fs, db.ctx, true)
+	if err == nil {
+		_, err = db.replayWAL()
+	}
+	// NewStateDB has already reported the restore, so rather than
+	// starting over, every operation and sync is refused, see Loaded
+	if err != nil {
+		fmt.Println("StateDB.Restore:", err)
+		db.loadErr = fmt.Errorf("StateDB.Restore: %w", err)
+	}
+	close(db.loaded)
+}
+
+// Retrieves the immutable, mutable and delta checkpoints
+// of the context and replays the deltas
+func (db *StateDB) load(fs Persistence, ctx *Context, lazy bool) error {
+	var err error
+	opts := db.opts
+
+	if ctx.Dedup {
+		db.immutable, db.chunks, db.lazy, err = retrieveManifest(fs, ctx, opts, lazy)
+	} else {
+		db.immutable, err = retrieveImmutable(fs, ctx, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := opts.migrations().migrateImmutable(db.immutable, db.lazy); err != nil {
+		return err
+	}
 
 	// there should always be a mutable cpt really..
 	if ctx.MCNT > 0 {
-		mut, err := retrieveMutable(fs, ctx)
+		mut, err := retrieveMutable(fs, ctx, opts)
 		if err != nil {
 			db.immutable = nil
-			return nil, err
+			return err
+		}
+		if err := opts.migrations().migrateMutable(mut); err != nil {
+			return err
 		}
 		db.mutable = mut
 	}
@@ -51,17 +113,33 @@ func restore(fs Persistence) (*StateDB, error) {
 	db.ctx = ctx
 
 	if ctx.DCNT != 0 {
-		deltas, err := retrieveDeltas(fs, ctx)
+		deltas, err := retrieveDeltas(fs, ctx, opts)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if err := db.replayDeltas(deltas); err != nil {
-			return nil, err
+			return err
 		}
+		opts.report(RestoreReplay, "", 0, len(deltas))
 	}
 
-	return db, nil
+	opts.report(RestoreDone, "", 0, db.immutable.count())
+	return nil
+}
+
+// Returns the context a restore starts from: the most recent one,
+// or the one of the global checkpoint of a Coordinated database
+func restoreContext(fs Persistence, opts *Options) (*Context, error) {
+	if opts.coordinated() {
+		return globalContext(fs, opts.GID)
+	}
+	ctx, err := retrieveContext(fs)
+	if err != nil {
+		// No previous checkpoint was registered
+		return nil, NoCheckpointError
+	}
+	return ctx, nil
 }
 
 func retrieveContext(fs Persistence) (*Context, error) {
@@ -113,42 +191,62 @@ func decodeContext(data []byte) (*Context, error) {
 	return ctx, nil
 }
 
-func retrieveImmutable(fs Persistence, ctx *Context) (ImmKeyTypeMap, error) {
+func retrieveImmutable(fs Persistence, ctx *Context, opts *Options) (ImmKeyTypeMap, error) {
 	path := ctx.ImmPath()
 	data, err := fs.Get(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return decodeImmutable(data)
+	imm, err := decodeImmutable(data)
+	if err != nil {
+		return nil, err
+	}
+	opts.report(RestoreImmutable, path, len(data), imm.count())
+
+	return imm, nil
 }
 
-func retrieveMutable(fs Persistence, ctx *Context) (MutKeyTypeMap, error) {
+func retrieveMutable(fs Persistence, ctx *Context, opts *Options) (MutKeyTypeMap, error) {
 	path := ctx.MutPath()
 	data, err := fs.Get(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return decodeMutable(data)
+	mut, err := decodeMutable(data)
+	if err != nil {
+		return nil, err
+	}
+	opts.report(RestoreMutable, path, len(data), mut.count())
+
+	return mut, nil
 }
 
 type DeltaGet struct {
 	id   int
+	path string
+	size int
 	data DeltaTypeMap
 	err  error
 }
 
-func retrieveDeltas(fs Persistence, ctx *Context) ([]DeltaTypeMap, error) {
+func retrieveDeltas(fs Persistence, ctx *Context, opts *Options) ([]DeltaTypeMap, error) {
+	return retrieveDeltaPaths(fs, ctx.DeltaPaths(), opts)
+}
+
+// Retrieves the delta checkpoints in parallel, in the order of paths
+func retrieveDeltaPaths(fs Persistence, paths []string, opts *Options) ([]DeltaTypeMap, error) {
 
-	paths := ctx.DeltaPaths()
 	deltas := make([]DeltaTypeMap, len(paths))
 
-	res := make(chan *DeltaGet, 0)
+	// buffered, so the remaining gets never block on an early return
+	res := make(chan *DeltaGet, len(paths))
 	for i, path := range paths {
 		go func(path string, id int) {
 			dg := &DeltaGet{
-				id: id,
+				id:   id,
+				path: path,
 			}
 			// retrieve binary data from fs
 			data, err := fs.Get(path)
@@ -157,6 +255,7 @@ func retrieveDeltas(fs Persistence, ctx *Context) ([]DeltaTypeMap, error) {
 				res <- dg
 				return
 			}
+			dg.size = len(data)
 			// decode into delta structure
 			tm, err := decodeDelta(data)
 			if err != nil {
@@ -177,6 +276,7 @@ func retrieveDeltas(fs Persistence, ctx *Context) ([]DeltaTypeMap, error) {
 			return nil, dg.err
 		}
 		deltas[dg.id] = dg.data
+		opts.report(RestoreDelta, dg.path, dg.size, dg.data.count())
 	}
 	return deltas, nil
 }
@@ -188,8 +288,29 @@ func (db *StateDB) replayDeltas(deltas []DeltaTypeMap) error {
 		return nil
 	}
 
+	if err := db.replay(deltas); err != nil {
+		return err
+	}
+
+	// every mutable state must belong to an immutable one
+	if kts := orphans(db.immutable, db.mutable); len(kts) > 0 {
+		return fmt.Errorf("StateDB.Replay: %d mutable states without an immutable state, the first being %s",
+			len(kts), kts[0].String())
+	}
+
+	db.delta = nil
+
+	return nil
+}
+
+// Applies the operations of the deltas, in order, to the immutable states
+func (db *StateDB) replay(deltas []DeltaTypeMap) error {
+
 	// for every type of state in the delta
 	for _, delta := range deltas {
+		if err := db.opts.migrations().migrateDelta(delta); err != nil {
+			return err
+		}
 		for _, m := range delta {
 			// for every StateOp in Delta
 			for _, st_op := range m {
@@ -204,29 +325,31 @@ func (db *StateDB) replayDeltas(deltas []DeltaTypeMap) error {
 					if db.immutable.contains(&st_op.KT) {
 						return errors.New("StateDB.Replay: Trying to replay CREATE of already existing KeyType:" + st_op.KT.String())
 					}
-					db.insertImmutable(&st_op.KT, st_op.Val)
+					db.insertImmutable(&st_op.KT, st_op.Val, st_op.Ver)
 				}
 			}
 		}
 	}
 
-	// validate
-	immSize := 0
-	for _, t := range db.immutable {
-		immSize += len(t)
-	}
-	mutSize := 0
-	for _, t := range db.mutable {
-		mutSize += len(t)
-	}
+	return nil
+}
 
-	if mutSize > immSize {
-		panic("StateDB.replayDelta: The number of mutable states > immutable ones.")
+// Returns the KeyTypes of the mutable states that
+// have no immutable counterpart, sorted
+func orphans(imm ImmKeyTypeMap, mut MutKeyTypeMap) []*KeyType {
+	kts := []*KeyType{}
+	for _, states := range mut {
+		for _, ms := range states {
+			if !imm.contains(&ms.KT) {
+				kt := ms.KT
+				kts = append(kts, &kt)
+			}
+		}
 	}
-
-	db.delta = nil
-
-	return nil
+	sort.Slice(kts, func(i, j int) bool {
+		return kts[i].String() < kts[j].String()
+	})
+	return kts
 }
 
 // The argument should be a current cpt_dir:
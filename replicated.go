@@ -0,0 +1,490 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRepairInterval = time.Minute
+)
+
+var (
+	QuorumError = errors.New("ReplicatedPersistence: write quorum was not reached")
+)
+
+type replica struct {
+	fs       Persistence
+	healthy  bool
+	lagging  map[string]bool // files missing on the replica: true=put, false=delete
+	inflight map[string]int  // files currently being written
+	written  map[string]int  // sequence number of the last context write
+}
+
+// ReplicatedPersistence fans every write out to a number of replicas and
+// returns once a write quorum has acknowledged. Replicas that fail or
+// fall behind have their lagging files repaired in the background from
+// the replicas that hold them.
+type ReplicatedPersistence struct {
+	replicas []*replica
+	quorum   int
+	cond     *sync.Cond // signalled when a write completes
+	seq      int        // orders the context writes
+	repair   chan bool
+	quit     chan bool
+	done     chan bool
+	once     sync.Once // closes quit
+	sync.Mutex
+}
+
+// Replicates to every replica and returns when at least quorum replicas
+// have acknowledged a write. Lagging replicas are repaired every interval,
+// and whenever a write fails.
+func NewReplicatedPersistence(quorum int, interval time.Duration, replicas ...Persistence) (*ReplicatedPersistence, error) {
+	if len(replicas) == 0 {
+		return nil, errors.New("ReplicatedPersistence: no replicas")
+	}
+
+	if quorum < 1 || quorum > len(replicas) {
+		return nil, fmt.Errorf("ReplicatedPersistence: invalid quorum 1 <= %d <= %d", quorum, len(replicas))
+	}
+
+	if interval <= 0 {
+		interval = DefaultRepairInterval
+	}
+
+	r := &ReplicatedPersistence{
+		quorum: quorum,
+		repair: make(chan bool, 1),
+		quit:   make(chan bool),
+		done:   make(chan bool),
+	}
+	for _, fs := range replicas {
+		if fs == nil {
+			return nil, errors.New("ReplicatedPersistence: replica cannot be <nil>")
+		}
+		r.replicas = append(r.replicas, &replica{
+			fs:       fs,
+			healthy:  true,
+			lagging:  make(map[string]bool),
+			inflight: make(map[string]int),
+			written:  make(map[string]int),
+		})
+	}
+
+	r.cond = sync.NewCond(&r.Mutex)
+
+	go r.repairLoop(interval)
+
+	return r, nil
+}
+
+// Init succeeds if a quorum of the replicas could be initialized
+func (r *ReplicatedPersistence) Init() error {
+	var err error
+	acks := 0
+	for _, rep := range r.replicas {
+		if e := rep.fs.Init(); e != nil {
+			err = e
+			r.setHealth(rep, false)
+			continue
+		}
+		acks++
+	}
+	if acks < r.quorum {
+		return fmt.Errorf("%s: %s", QuorumError.Error(), err.Error())
+	}
+	return nil
+}
+
+func (r *ReplicatedPersistence) Put(name string, data []byte) error {
+	return r.fanOut(name, func(fs Persistence) error {
+		return fs.Put(name, data)
+	}, true)
+}
+
+func (r *ReplicatedPersistence) Delete(name string) error {
+	return r.fanOut(name, func(fs Persistence) error {
+		return fs.Delete(name)
+	}, false)
+}
+
+// Writes to every replica and returns once the quorum has acknowledged,
+// or once enough replicas have failed that the quorum cannot be reached.
+// The remaining writes finish in the background.
+func (r *ReplicatedPersistence) fanOut(name string, op func(Persistence) error, put bool) error {
+
+	res := make(chan error, len(r.replicas))
+	isCtx := put && isContextPath(name)
+
+	r.Lock()
+	r.seq++
+	seq := r.seq
+	for _, rep := range r.replicas {
+		if !isCtx {
+			rep.lagging[name] = put
+			rep.inflight[name]++
+		}
+	}
+	r.Unlock()
+
+	for _, rep := range r.replicas {
+		go func(rep *replica) {
+			if isCtx {
+				r.Lock()
+				// a context is only written to a replica that holds every
+				// file written before it, otherwise it is left to the repair
+				for len(rep.inflight) > 0 {
+					r.cond.Wait()
+				}
+				if len(rep.lagging) > 0 {
+					rep.lagging[name] = true
+					r.Unlock()
+					res <- fmt.Errorf("ReplicatedPersistence: replica is lagging behind, %s deferred", name)
+					return
+				}
+				// never overwrite a more recent context
+				if rep.written[name] > seq {
+					r.Unlock()
+					res <- nil
+					return
+				}
+				rep.written[name] = seq
+				rep.lagging[name] = put
+				rep.inflight[name]++
+				r.Unlock()
+			}
+
+			err := op(rep.fs)
+
+			r.Lock()
+			rep.inflight[name]--
+			if rep.inflight[name] == 0 {
+				delete(rep.inflight, name)
+			}
+			if err == nil {
+				delete(rep.lagging, name)
+			}
+			rep.healthy = err == nil
+			r.cond.Broadcast()
+			r.Unlock()
+
+			if err != nil {
+				r.Repair()
+			}
+			res <- err
+		}(rep)
+	}
+
+	acks, fails := 0, 0
+	var err error
+	for range r.replicas {
+		e := <-res
+		if e == nil {
+			acks++
+		} else {
+			fails++
+			err = e
+		}
+		if acks >= r.quorum {
+			return nil
+		}
+		if fails > len(r.replicas)-r.quorum {
+			break
+		}
+	}
+	return fmt.Errorf("%s (%d/%d): %s", QuorumError.Error(), acks, r.quorum, err.Error())
+}
+
+// Get reads from the first healthy replica that is not lagging behind on
+// the file. Context files are read from every replica and the most recent
+// one is returned.
+func (r *ReplicatedPersistence) Get(name string) ([]byte, error) {
+	if isContextPath(name) {
+		return r.getContext(name)
+	}
+
+	var err error
+	for _, rep := range r.readOrder(name) {
+		var data []byte
+		if data, err = rep.fs.Get(name); err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+func (r *ReplicatedPersistence) getContext(name string) ([]byte, error) {
+	var data []byte
+	var ctx *Context
+	var err error
+
+	for _, rep := range r.readOrder(name) {
+		d, e := rep.fs.Get(name)
+		if e != nil {
+			err = e
+			continue
+		}
+		c, e := decodeContext(d)
+		if e != nil {
+			err = e
+			continue
+		}
+		if ctx == nil || MostRecent(c, ctx) == c {
+			data, ctx = d, c
+		}
+	}
+
+	if ctx == nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Returns the healthy replicas that are up to date on the file,
+// followed by the remaining replicas as a fallback
+func (r *ReplicatedPersistence) readOrder(name string) []*replica {
+	r.Lock()
+	defer r.Unlock()
+
+	first := make([]*replica, 0, len(r.replicas))
+	last := []*replica{}
+	for _, rep := range r.replicas {
+		if _, lag := rep.lagging[name]; rep.healthy && !lag {
+			first = append(first, rep)
+		} else {
+			last = append(last, rep)
+		}
+	}
+	return append(first, last...)
+}
+
+// List returns the union of the files on every replica
+func (r *ReplicatedPersistence) List(prefix string) ([]string, error) {
+	var err error
+	seen := make(map[string]bool)
+	items := []string{}
+	acks := 0
+	for _, rep := range r.replicas {
+		ls, e := rep.fs.List(prefix)
+		if e != nil {
+			err = e
+			continue
+		}
+		acks++
+		for _, l := range ls {
+			if !seen[l] {
+				seen[l] = true
+				items = append(items, l)
+			}
+		}
+	}
+	if acks == 0 {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Number of files each replica is lagging behind
+func (r *ReplicatedPersistence) Lagging() []int {
+	r.Lock()
+	defer r.Unlock()
+	lag := make([]int, len(r.replicas))
+	for i, rep := range r.replicas {
+		lag[i] = len(rep.lagging)
+	}
+	return lag
+}
+
+// Repair signals the background repair to run as soon as possible
+func (r *ReplicatedPersistence) Repair() {
+	select {
+	case r.repair <- true:
+	default:
+	}
+}
+
+// Stops the background repair. Closing it again has no effect.
+func (r *ReplicatedPersistence) Close() error {
+	r.once.Do(func() {
+		close(r.quit)
+	})
+	<-r.done
+	return nil
+}
+
+func (r *ReplicatedPersistence) setHealth(rep *replica, healthy bool) {
+	r.Lock()
+	rep.healthy = healthy
+	r.Unlock()
+}
+
+func (r *ReplicatedPersistence) repairLoop(interval time.Duration) {
+	defer close(r.done)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case <-tick.C:
+		case <-r.repair:
+		}
+		if err := r.RepairNow(); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// RepairNow copies the freshest context and every file it references
+// to the replicas that do not hold it, and retries the writes that
+// previously failed on a lagging replica.
+func (r *ReplicatedPersistence) RepairNow() error {
+	var err error
+
+	// the files of the most recent context
+	if data, e := r.getContext("cpt0.nfo"); e == nil {
+		if e := r.repairContext(data); e != nil {
+			err = e
+		}
+	}
+	if data, e := r.getContext("cpt1.nfo"); e == nil {
+		if e := r.repairContext(data); e != nil {
+			err = e
+		}
+	}
+
+	// every file that failed or never arrived
+	for _, rep := range r.replicas {
+		if e := r.repairReplica(rep); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Marks every file referenced by the context as lagging
+// on the replicas that are missing it
+func (r *ReplicatedPersistence) repairContext(data []byte) error {
+	ctx, err := decodeContext(data)
+	if err != nil {
+		return err
+	}
+
+	for _, rep := range r.replicas {
+		if d, e := rep.fs.Get(ctx.CtxPath()); e == nil {
+			if c, e := decodeContext(d); e == nil && MostRecent(ctx, c) == c {
+				continue
+			}
+		}
+		paths, err := checkpointFiles(r, ctx)
+		if err != nil {
+			return err
+		}
+		r.Lock()
+		for _, path := range paths {
+			if _, ok := rep.inflight[path]; !ok {
+				rep.lagging[path] = true
+			}
+		}
+		r.Unlock()
+	}
+	return nil
+}
+
+// Copies every lagging file to the replica, context files last
+func (r *ReplicatedPersistence) repairReplica(rep *replica) error {
+	r.Lock()
+	files, ctxs := []string{}, []string{}
+	for name := range rep.lagging {
+		if _, ok := rep.inflight[name]; ok {
+			continue
+		}
+		if isContextPath(name) {
+			ctxs = append(ctxs, name)
+		} else {
+			files = append(files, name)
+		}
+	}
+	r.Unlock()
+
+	for _, name := range files {
+		if err := r.repairFile(rep, name, false); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range ctxs {
+		if err := r.repairFile(rep, name, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ReplicatedPersistence) repairFile(rep *replica, name string, isCtx bool) error {
+	r.Lock()
+	put, ok := rep.lagging[name]
+	// a context is only repaired once every other file is in place
+	if isCtx {
+		for n := range rep.lagging {
+			if !isContextPath(n) {
+				ok = false
+			}
+		}
+	}
+	r.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var err error
+	if put {
+		var data []byte
+		if isCtx {
+			data, err = r.getContext(name)
+		} else {
+			data, err = r.getFrom(rep, name)
+		}
+		if err == nil {
+			err = rep.fs.Put(name, data)
+		}
+	} else {
+		err = rep.fs.Delete(name)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	rep.healthy = err == nil
+	if err != nil {
+		return fmt.Errorf("ReplicatedPersistence: repair of %s failed: %s", name, err.Error())
+	}
+	delete(rep.lagging, name)
+	return nil
+}
+
+// Reads the file from any other replica that holds it
+func (r *ReplicatedPersistence) getFrom(dst *replica, name string) ([]byte, error) {
+	err := errors.New("ReplicatedPersistence: no replica holds " + name)
+	for _, rep := range r.readOrder(name) {
+		if rep == dst {
+			continue
+		}
+		r.Lock()
+		_, lag := rep.lagging[name]
+		r.Unlock()
+		if lag {
+			continue
+		}
+		data, e := rep.fs.Get(name)
+		if e == nil {
+			return data, nil
+		}
+		err = e
+	}
+	return nil, err
+}
@@ -0,0 +1,87 @@
+package statedb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplicatedQuorum(t *testing.T) {
+	a, b, c := newMemFS(), newMemFS(), newMemFS()
+	rp, err := NewReplicatedPersistence(2, time.Hour, a, b, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rp.Close()
+
+	c.setFail("1/imm.cpt", true)
+	if err := rp.Put("1/imm.cpt", []byte("imm")); err != nil {
+		t.Fatalf("quorum of 2/3 should accept the write: %s", err)
+	}
+
+	b.setFail("1/mut_1.cpt", true)
+	c.setFail("1/mut_1.cpt", true)
+	if err := rp.Put("1/mut_1.cpt", []byte("mut")); err == nil {
+		t.Fatal("write acknowledged by 1/3 replicas should fail")
+	}
+}
+
+// Waits until the write of the file to replica i has landed, or has
+// been deferred to the repair
+func replicaSettled(t *testing.T, rp *ReplicatedPersistence, i int, name string) {
+	rep := rp.replicas[i]
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		rp.Lock()
+		_, inflight := rep.inflight[name]
+		lagging := rep.lagging[name]
+		rp.Unlock()
+		if !inflight && (lagging || rep.fs.(*memFS).has(name)) {
+			return
+		}
+	}
+	t.Fatalf("the write of %s to replica %d did not settle", name, i)
+}
+
+func TestReplicatedRepair(t *testing.T) {
+	a, b := newMemFS(), newMemFS()
+	rp, _ := NewReplicatedPersistence(1, time.Hour, a, b)
+	// the repair is driven by RepairNow alone
+	rp.Close()
+	// a second Close has no effect
+	defer rp.Close()
+
+	ctx := NewContext().newZeroContext()
+	b.setFail(ctx.ImmPath(), true)
+
+	rp.Put(ctx.ImmPath(), []byte("imm"))
+	rp.Put(ctx.MutPath(), []byte("mut"))
+	putContext(t, rp, ctx)
+
+	replicaSettled(t, rp, 1, ctx.MutPath())
+	replicaSettled(t, rp, 1, ctx.CtxPath())
+	if b.has(ctx.CtxPath()) {
+		t.Fatal("context written to a replica that is missing the immutable checkpoint")
+	}
+
+	// the freshest replica is used for reads
+	got, err := retrieveContext(rp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID() != ctx.ID() {
+		t.Fatalf("read context %s, expected %s", got.ID(), ctx.ID())
+	}
+
+	b.setFail(ctx.ImmPath(), false)
+	if err := rp.RepairNow(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range ctx.Paths() {
+		if !b.has(path) {
+			t.Fatalf("%s was not repaired", path)
+		}
+	}
+	if lag := rp.Lagging(); lag[1] != 0 {
+		t.Fatalf("replica is still lagging %d files", lag[1])
+	}
+}
@@ -17,45 +17,29 @@ type entry struct {
 	imm *ImmState
 	mut *MutState
 	kt  *KeyType
+	db  *StateDB
 }
 
 type Iterator struct {
 	i       int
 	entries []*entry
 	db      *StateDB
+	err     error
 }
 
-// func (iter *Iter) All(result interface{}) error {
-// 	resultv := reflect.ValueOf(result)
-// 	if resultv.Kind() != reflect.Ptr || resultv.Elem().Kind() != reflect.Slice {
-// 		panic("result argument must be a slice address")
-// 	}
-// 	slicev := resultv.Elem()
-// 	slicev = slicev.Slice(0, slicev.Cap())
-// 	elemt := slicev.Type().Elem()
-// 	i := 0
-// 	for {
-// 		if slicev.Len() == i {
-// 			elemp := reflect.New(elemt)
-// 			if !iter.Next(elemp.Interface()) {
-// 				break
-// 			}
-// 			slicev = reflect.Append(slicev, elemp.Elem())
-// 			slicev = slicev.Slice(0, slicev.Cap())
-// 		} else {
-// 			if !iter.Next(slicev.Index(i).Addr().Interface()) {
-// 				break
-// 			}
-// 		}
-// 		i++
-// 	}
-// 	resultv.Elem().Set(slicev.Slice(0, i))
-// 	return iter.Close()
-// }
+// Err returns the error that stopped the iteration, if any
+func (it *Iterator) Err() error {
+	if it == nil {
+		return nil
+	}
+	return it.err
+}
 
+// Next decodes the next entry into imm, and returns false when every
+// entry has been restored or an error occurred (see Err).
 func (it *Iterator) Next(imm interface{}) (*KeyType, bool) {
 
-	if it == nil {
+	if it == nil || it.err != nil {
 		return nil, false
 	}
 
@@ -65,46 +49,51 @@ func (it *Iterator) Next(imm interface{}) (*KeyType, bool) {
 	}
 	entry := it.entries[it.i]
 
-	// immutable
-	buff := bytes.NewBuffer(entry.imm.Val)
-	dec := gob.NewDecoder(buff)
-
-	if err := dec.Decode(imm); err != nil {
-		fmt.Println(err)
+	if err := entry.restore(imm); err != nil {
+		it.err = err
 		return nil, false
 	}
 	it.i++
 
-	m, ok := imm.(Mutable)
-	if ok {
-		mut := m.Mutable()
-		if mut == nil {
-			return entry.kt, true
-		}
+	return entry.kt, true
+}
 
-		if entry.mut == nil {
-			panic("there should be something here!")
-			return entry.kt, true
-		}
+// Decodes the immutable entry into imm, and if imm is Mutable, decodes
+// the mutable entry into the pointer returned by Mutable() which is then
+// used for every subsequent checkpoint.
+func (e *entry) restore(imm interface{}) error {
 
-		mutv := reflect.ValueOf(mut)
-		if err := validateMutableEntry(mutv); err != nil {
-			return nil, false
-		}
+	if err := Decode(e.imm.Val, imm); err != nil {
+		return fmt.Errorf("StateDB.Restore: %s: %s", e.kt.String(), err.Error())
+	}
 
-		// update the v with the new pointer value
-		entry.mut.v = mutv
+	m, ok := imm.(Mutable)
+	if !ok {
+		return nil
+	}
 
-		// mutable
-		buff = bytes.NewBuffer(entry.mut.Val)
-		dec = gob.NewDecoder(buff)
+	mut := m.Mutable()
+	if mut == nil {
+		return nil
+	}
 
-		if err := dec.Decode(mut); err != nil {
-			fmt.Println(err)
-			return nil, false
-		}
+	if e.mut == nil {
+		return fmt.Errorf("StateDB.Restore: %s has no checkpointed mutable state", e.kt.String())
 	}
-	return entry.kt, true
+
+	mutv := reflect.ValueOf(mut)
+	if err := validateMutableEntry(mutv); err != nil {
+		return err
+	}
+
+	if err := Decode(e.mut.Val, mut); err != nil {
+		return fmt.Errorf("StateDB.Restore: mutable %s: %s", e.kt.String(), err.Error())
+	}
+
+	// update the v with the new pointer value
+	e.db.bind(e.mut, mutv)
+
+	return nil
 }
 
 func Decode(val []byte, i interface{}) error {
@@ -122,44 +111,52 @@ func (db *StateDB) RestoreSingle(imm interface{}) error {
 
 	typ := ReflectTypeM(imm)
 
-	states, ok := db.immutable[typ]
+	if err := db.materialize(typ); err != nil {
+		return err
+	}
+
+	// the states are read on the stateLoop, which inserts and removes them
+	var n int
+	var ok bool
+	var s *ImmState
+	var ms *MutState
+	db.serve(func() {
+		var states ImmStateMap
+		states, ok = db.immutable[typ]
+		n = len(states)
+		for _, s = range states {
+			ms = db.mutable.lookup(&s.KT)
+			break
+		}
+	})
 	if !ok {
 		return fmt.Errorf("StateDB.Restore: No object of type '%s' found", typ)
 	}
 
-	if len(states) == 0 {
+	if n == 0 {
 		return errors.New("RestoreSigne: No item of type " + typ)
 	}
 
-	for _, s := range states {
-		kt := &s.KT
-
-		if err := Decode(s.Val, imm); err != nil {
-			return err
-		}
-
-		m, ok := imm.(Mutable)
-		if !ok {
-			return nil
-		}
+	if err := Decode(s.Val, imm); err != nil {
+		return err
+	}
 
-		ms := db.mutable.lookup(kt)
-		if ms.Val == nil {
-			return nil
-		}
-		if ms != nil {
-			mut := m.Mutable()
-			mutv := reflect.ValueOf(mut)
-			if err := validateMutableEntry(mutv); err != nil {
-				return err
-			}
-			ms.v = mutv
+	m, ok := imm.(Mutable)
+	if !ok {
+		return nil
+	}
 
-			Decode(ms.Val, mut)
-		}
-		// break after one restore..
-		break
+	if ms == nil || ms.Val == nil {
+		return nil
 	}
+	mut := m.Mutable()
+	mutv := reflect.ValueOf(mut)
+	if err := validateMutableEntry(mutv); err != nil {
+		return err
+	}
+	db.bind(ms, mutv)
+
+	Decode(ms.Val, mut)
 	return nil
 }
 
@@ -169,21 +166,43 @@ func (db *StateDB) RestoreIter(typeID string) (*Iterator, error) {
 		return nil, errors.New("StateDB: database has not been initialized. Call NewStateDB(...)")
 	}
 
-	states, ok := db.immutable[typeID]
-	if !ok {
-		return nil, fmt.Errorf("StateDB.RestoreIter: TypeID '%s' does not exist", typeID)
+	entries, err := db.entries(typeID)
+	if err != nil {
+		return nil, err
 	}
-	entries := []*entry{}
-	for _, state := range states {
-		kt := &state.KT
-		mp := &entry{
-			imm: state,
-			kt:  kt,
-		}
-		mp.mut = db.mutable.lookup(kt)
 
-		entries = append(entries, mp)
+	return &Iterator{entries: entries}, nil
+}
+
+// Returns the immutable and mutable entries of every state of the type
+func (db *StateDB) entries(typeID string) ([]*entry, error) {
+
+	if err := db.materialize(typeID); err != nil {
+		return nil, err
 	}
 
-	return &Iterator{entries: entries}, nil
+	// the states are read on the stateLoop, which inserts and removes them
+	var entries []*entry
+	var ok bool
+	db.serve(func() {
+		var states ImmStateMap
+		states, ok = db.immutable[typeID]
+		entries = make([]*entry, 0, len(states))
+		for _, state := range states {
+			kt := &state.KT
+			mp := &entry{
+				imm: state,
+				kt:  kt,
+				db:  db,
+			}
+			mp.mut = db.mutable.lookup(kt)
+
+			entries = append(entries, mp)
+		}
+	})
+	if !ok {
+		return nil, fmt.Errorf("StateDB.RestoreIter: TypeID '%s' does not exist", typeID)
+	}
+
+	return entries, nil
 }
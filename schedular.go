@@ -1,9 +1,10 @@
 package statedb
 
 import (
-	// "errors"
+	"errors"
 	"fmt"
 	// "github.com/paddie/statedb/monitor"
+	"sync"
 	"time"
 )
 
@@ -17,40 +18,61 @@ type Model interface {
 }
 
 type ModelNexus struct {
-	errChan      chan error
 	statChan     chan Stat
 	cptQueryChan chan *CheckpointQuery
 	quitChan     chan bool
+	done         chan bool // closed once educate returns
+	errs         []error   // reported by the model and the monitor
+	sync.Mutex
 }
 
 func NewModelNexus() *ModelNexus {
 	return &ModelNexus{
 		statChan:     make(chan Stat, 1),
 		cptQueryChan: make(chan *CheckpointQuery),
-		errChan:      make(chan error),
 		quitChan:     make(chan bool),
+		done:         make(chan bool),
 	}
 }
 
-func (nx *ModelNexus) Quit() {
-	// send quit signal to model
-	nx.quitChan <- true
+// Stops the monitor and the model, and returns every
+// error they reported
+func (nx *ModelNexus) Quit() error {
+	// send quit signal to model, unless
+	// it already returned on an error
+	select {
+	case nx.quitChan <- true:
+	case <-nx.done:
+	}
+	<-nx.done
 	// shut down all sending channels..
 	close(nx.statChan)
 	close(nx.cptQueryChan)
 	close(nx.quitChan)
+
+	nx.Lock()
+	defer nx.Unlock()
+	return errors.Join(nx.errs...)
+}
+
+func (nx *ModelNexus) report(err error) {
+	fmt.Println("Schedular:", err)
+	nx.Lock()
+	nx.errs = append(nx.errs, err)
+	nx.Unlock()
 }
 
 func educate(model Model, monitor Monitor, nx *ModelNexus, bid float64) {
+	defer close(nx.done)
 
 	trace, err := monitor.Trace()
 	if err != nil {
-		nx.errChan <- err
+		nx.report(err)
 		return
 	}
 
 	if err := model.Train(trace, bid); err != nil {
-		nx.errChan <- err
+		nx.report(err)
 		return
 	}
 
@@ -58,7 +80,7 @@ func educate(model Model, monitor Monitor, nx *ModelNexus, bid float64) {
 	errChan := make(chan error)
 
 	if err = monitor.Start(priceChan, errChan); err != nil {
-		nx.errChan <- err
+		nx.report(err)
 		return
 	}
 
@@ -69,7 +91,7 @@ func educate(model Model, monitor Monitor, nx *ModelNexus, bid float64) {
 		case q := <-nx.cptQueryChan:
 			do, err := model.PointOfConsistency()
 			if err != nil {
-				nx.errChan <- err
+				nx.report(err)
 			}
 			if do {
 				fmt.Println("Schedular: take checkpoint!")
@@ -81,12 +103,12 @@ func educate(model Model, monitor Monitor, nx *ModelNexus, bid float64) {
 			err := model.PriceUpdate(pp.Price(), pp.Time())
 			timeline.PriceChange(pp.Price())
 			if err != nil {
-				nx.errChan <- err
+				nx.report(err)
 			}
 		case s := <-nx.statChan:
 			err := model.StatUpdate(s)
 			if err != nil {
-				nx.errChan <- err
+				nx.report(err)
 			}
 		case _ = <-nx.quitChan:
 			// shut down monitor..
@@ -95,16 +117,16 @@ func educate(model Model, monitor Monitor, nx *ModelNexus, bid float64) {
 			// shut down model..
 			err := model.Quit()
 			if err != nil {
-				nx.errChan <- err
+				nx.report(err)
 			}
 			fmt.Println("Model has been shut down")
 			return
 		case err := <-errChan:
 			fmt.Printf("Monitor panicked: <%s>", err.Error())
-			nx.errChan <- err
+			nx.report(err)
 			err = model.Quit()
 			if err != nil {
-				nx.errChan <- err
+				nx.report(err)
 			}
 			fmt.Println("Model has been shut down")
 			return
@@ -1,72 +1,101 @@
 package schedular
 
-// import (
-// 	// "errors"
-// 	// "github.com/paddie/statedb/monitor"
-// 	"time"
-// )
-
-// type Exponential struct {
-// 	Mean         float64
-// 	Lambda       float64
-// 	Bid          float64
-// 	Stat         *Stat
-// 	Price        float64
-// 	T_Restore    float64 // minutes
-// 	T_Checkpoint float64 // minutes
-// }
-
-// func NewExponential() *Exponential {
-// 	return &Exponential{}
-// }
-
-// func (e *Exponential) Name() string {
-// 	return "ExponentialDistribution"
-// }
-
-// func (e *Exponential) take(t, t_p float64) float64 {
-// 	return 0.0
-// }
-
-// func (e *Exponential) skip(t, t_p float64) float64 {
-// 	return 0.0
-// }
-
-// func (e *Exponential) t(w, t_p float64) float64 {
-// 	return 0.0
-// }
-
-// func (e *Exponential) Train(trace []PricePoint, bid float64) error {
-// 	e.Mean, _, _, _ = trace.AliveStats(bid)
-// 	e.Lambda = 1.0 / e.Mean
-// 	e.Price = trace.Latest.SpotPrice
-// 	return nil
-// }
-
-// func (e *Exponential) Checkpoint(timeSinceLastCpt, avgSyncTime, aliveMinutes float64) (bool, error) {
-
-// 	// to get stats a checkpoint is required
-// 	// - stats are only sent when a checkpoint is completed
-// 	if e.Stat == nil {
-// 		return true, nil
-// 	}
-
-// 	w := timeSinceLastCpt
-// 	t_p := aliveMinutes
-
-// 	return e.take(w, t_p) < e.skip(w, t_p), nil
-// }
-
-// func (e *Exponential) StatUpdate(restartTimeMinutes, cptTimeMinutes float64) error {
-
-// 	e.T_Restore = restartTimeMinutes
-// 	e.T_Checkpoint = cptTimeMinutes
-
-// 	return nil
-// }
-
-// func (e *Exponential) PriceUpdate(p float64, _ time.Time) error {
-// 	e.Price = p
-
-// 	return nil
-// }
+import (
+	"fmt"
+	"github.com/paddie/statedb"
+	"math"
+	"time"
+)
+
+// Exponential models the revocations as a Poisson process, with the
+// rate fitted from how long the price stayed below the bid. At a point
+// of consistency it checkpoints if the work expected to be lost before
+// the next one exceeds the cost of the checkpoint.
+type Exponential struct {
+	rev      *revocations
+	cost     time.Duration // expected write time of a checkpoint
+	lastCpt  time.Time
+	lastSync time.Time
+	revoked  bool // the price rose above the bid
+}
+
+func NewExponential() *Exponential {
+	return &Exponential{}
+}
+
+func (e *Exponential) Name() string {
+	return "ExponentialDistribution"
+}
+
+func (e *Exponential) Train(trace []statedb.PricePoint, bid float64) error {
+	e.rev = newRevocations(trace, bid)
+	now := time.Now()
+	e.lastCpt, e.lastSync = now, now
+	fmt.Printf("<Exponential> revocation rate: %.4f/h\n", e.lambda()*3600)
+	return nil
+}
+
+func (e *Exponential) StatUpdate(stat statedb.Stat) error {
+	// also sent on registrations, so only the cost is refreshed
+	if c := stat.ExpWriteCheckpoint(); c > 0 {
+		e.cost = c
+	}
+	return nil
+}
+
+func (e *Exponential) PriceUpdate(p float64, t time.Time) error {
+	count := e.rev.count
+	e.rev.add(p, t)
+	if e.rev.count > count {
+		fmt.Printf("<Exponential> %.4f exceeds the bid: checkpoint at next sync\n", p)
+		e.revoked = true
+	}
+	return nil
+}
+
+func (e *Exponential) PointOfConsistency() (bool, error) {
+	now := time.Now()
+	// the next point of consistency is expected as far off as the last
+	w, s := now.Sub(e.lastCpt), now.Sub(e.lastSync)
+	e.lastSync = now
+
+	// the cost is unknown before the first checkpoint, and
+	// the rate if the price never stayed below the bid
+	if e.cost <= 0 || e.lambda() <= 0 || e.revoked || e.take(w) < e.skip(w, s) {
+		e.revoked = false
+		e.lastCpt = now
+		return true, nil
+	}
+	return false, nil
+}
+
+func (e *Exponential) Quit() error {
+	return nil
+}
+
+// Revocations per second, the maximum likelihood estimate of the
+// revocations over the time alive, or 0 if unknown
+func (e *Exponential) lambda() float64 {
+	m := e.rev.mean()
+	if m <= 0 {
+		return 0
+	}
+	return 1 / m.Seconds()
+}
+
+// Probability of a revocation within d
+func (e *Exponential) revocation(d time.Duration) float64 {
+	return 1 - math.Exp(-e.lambda()*d.Seconds())
+}
+
+// Expected cost, in seconds, of checkpointing the work w: the write
+// time, and w if the instance is revoked before the write completes
+func (e *Exponential) take(w time.Duration) float64 {
+	return e.cost.Seconds() + e.revocation(e.cost)*w.Seconds()
+}
+
+// Expected cost, in seconds, of skipping the checkpoint of the work w:
+// w if the instance is revoked before the next point of consistency s
+func (e *Exponential) skip(w, s time.Duration) float64 {
+	return e.revocation(s) * w.Seconds()
+}
@@ -0,0 +1,111 @@
+package schedular
+
+import (
+	"github.com/paddie/statedb"
+	"math"
+	"testing"
+	"time"
+)
+
+// Trains the model on a trace that is revoked every period
+func trainExponential(t *testing.T, period time.Duration) *Exponential {
+	start := time.Now().Add(-10 * period)
+	prices := []float64{}
+	for i := 0; i < 10; i++ {
+		prices = append(prices, 0.5, 2.0)
+	}
+	e := NewExponential()
+	if err := e.Train(syntheticTrace(start, period/2, prices...), 1.0); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestExponentialFit(t *testing.T) {
+	// alive half of every hour
+	e := trainExponential(t, time.Hour)
+	if l := e.lambda() * 1800; math.Abs(l-1) > 1e-9 {
+		t.Fatalf("expected a revocation every 30m alive, got %.4f", l)
+	}
+	if p := e.revocation(30 * time.Minute); math.Abs(p-(1-math.Exp(-1))) > 1e-9 {
+		t.Fatalf("unexpected probability of a revocation within 30m: %.4f", p)
+	}
+
+	// never below the bid
+	e = NewExponential()
+	e.Train(syntheticTrace(time.Now(), time.Hour, 2.0, 3.0), 1.0)
+	if l := e.lambda(); l != 0 {
+		t.Fatalf("expected an unknown rate, got %f", l)
+	}
+}
+
+func TestExponentialDecision(t *testing.T) {
+	for _, c := range []struct {
+		period time.Duration // between revocations
+		w, s   time.Duration // since the last checkpoint and point of consistency
+		cpt    bool
+	}{
+		// the work lost is likely, and exceeds the write time
+		{time.Hour, 2 * time.Hour, 10 * time.Minute, true},
+		// too little work to lose
+		{time.Hour, 30 * time.Second, 10 * time.Minute, false},
+		// revocations are rare
+		{1000 * time.Hour, 2 * time.Hour, 10 * time.Minute, false},
+		// but not if the points of consistency are too
+		{1000 * time.Hour, 2 * time.Hour, 100 * time.Hour, true},
+	} {
+		e := trainExponential(t, c.period)
+		e.cost = time.Minute
+		now := time.Now()
+		e.lastCpt, e.lastSync = now.Add(-c.w), now.Add(-c.s)
+		if cpt, _ := e.PointOfConsistency(); cpt != c.cpt {
+			t.Fatalf("period %s, work %s, next sync %s: expected %v", c.period, c.w, c.s, c.cpt)
+		}
+	}
+}
+
+func TestExponentialStats(t *testing.T) {
+	e := trainExponential(t, time.Hour)
+	e.cost = time.Minute
+	now := time.Now()
+	e.lastCpt, e.lastSync = now.Add(-2*time.Hour), now.Add(-10*time.Minute)
+
+	// registrations send stats between the syncs
+	for i := 0; i < 5; i++ {
+		e.StatUpdate(statedb.Stat{})
+	}
+	if cpt, _ := e.PointOfConsistency(); !cpt {
+		t.Fatal("the stats held the checkpoint back")
+	}
+	e.StatUpdate(statedb.Stat{})
+	if cpt, _ := e.PointOfConsistency(); cpt {
+		t.Fatal("expected no checkpoint right after the last")
+	}
+}
+
+func TestExponential(t *testing.T) {
+	e := trainExponential(t, time.Hour)
+	// without an estimate of the cost, always checkpoint
+	if cpt, _ := e.PointOfConsistency(); !cpt {
+		t.Fatal("expected a checkpoint before the first stat")
+	}
+
+	e.cost = time.Minute
+	if cpt, _ := e.PointOfConsistency(); cpt {
+		t.Fatal("expected no checkpoint right after the last")
+	}
+
+	// a revocation raises the rate, and checkpoints once
+	before := e.lambda()
+	e.PriceUpdate(0.5, time.Now())
+	e.PriceUpdate(2.0, time.Now().Add(time.Minute))
+	if e.lambda() <= before {
+		t.Fatalf("expected a higher rate than %f, got %f", before, e.lambda())
+	}
+	if cpt, _ := e.PointOfConsistency(); !cpt {
+		t.Fatal("expected a checkpoint once the price exceeds the bid")
+	}
+	if cpt, _ := e.PointOfConsistency(); cpt {
+		t.Fatal("expected a single checkpoint for the revocation")
+	}
+}
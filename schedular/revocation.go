@@ -0,0 +1,58 @@
+package schedular
+
+import (
+	"github.com/paddie/statedb"
+	"sort"
+	"time"
+)
+
+// Tracks how long the price stayed at or below the bid, and how often
+// it rose above it, revoking the instance
+type revocations struct {
+	bid         float64
+	alive       time.Duration
+	count       int
+	last        time.Time
+	up          bool // the price was at or below the bid
+	initialized bool
+}
+
+func newRevocations(trace []statedb.PricePoint, bid float64) *revocations {
+	r := &revocations{bid: bid}
+
+	// the trace is not necessarily in order
+	points := make([]statedb.PricePoint, len(trace))
+	copy(points, trace)
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time().Before(points[j].Time())
+	})
+	for _, pp := range points {
+		r.add(pp.Price(), pp.Time())
+	}
+	return r
+}
+
+func (r *revocations) add(price float64, t time.Time) {
+	if r.initialized && r.up {
+		if t.After(r.last) {
+			r.alive += t.Sub(r.last)
+		}
+		if price > r.bid {
+			r.count++
+		}
+	}
+	if t.After(r.last) {
+		r.last = t
+	}
+	r.up = price <= r.bid
+	r.initialized = true
+}
+
+// Mean time to revocation. Without a revocation, the time alive is a
+// lower bound. Returns 0 if the price never stayed below the bid.
+func (r *revocations) mean() time.Duration {
+	if r.count == 0 {
+		return r.alive
+	}
+	return r.alive / time.Duration(r.count)
+}
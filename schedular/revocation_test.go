@@ -0,0 +1,59 @@
+package schedular
+
+import (
+	"github.com/paddie/statedb"
+	"testing"
+	"time"
+)
+
+type point struct {
+	price float64
+	t     time.Time
+}
+
+func (p point) Price() float64  { return p.price }
+func (p point) Time() time.Time { return p.t }
+func (p point) Key() string     { return "test.key" }
+
+// Synthetic trace of a price point every step
+func syntheticTrace(start time.Time, step time.Duration, prices ...float64) []statedb.PricePoint {
+	trace := make([]statedb.PricePoint, len(prices))
+	for i, p := range prices {
+		trace[i] = point{p, start.Add(time.Duration(i) * step)}
+	}
+	return trace
+}
+
+func TestRevocations(t *testing.T) {
+	start := time.Now()
+	// alive for 3h and 2h, revoked twice
+	trace := syntheticTrace(start, time.Hour, 0.5, 0.6, 0.5, 1.5, 1.2, 0.4, 0.7, 2.0, 0.5)
+
+	// in reverse order, like the trace of a monitor may be
+	reversed := make([]statedb.PricePoint, len(trace))
+	for i, pp := range trace {
+		reversed[len(trace)-1-i] = pp
+	}
+	r := newRevocations(reversed, 1.0)
+	if r.alive != 5*time.Hour || r.count != 2 {
+		t.Fatalf("expected 5h alive and 2 revocations, got %s and %d", r.alive, r.count)
+	}
+	if m := r.mean(); m != 150*time.Minute {
+		t.Fatalf("expected a mean time to revocation of 2h30m, got %s", m)
+	}
+
+	// alive since the last price point, without a revocation
+	r.add(0.5, start.Add(10*time.Hour))
+	if r.alive != 7*time.Hour || r.count != 2 {
+		t.Fatalf("expected 7h alive and 2 revocations, got %s and %d", r.alive, r.count)
+	}
+
+	// never below the bid
+	if m := newRevocations(trace, 0.1).mean(); m != 0 {
+		t.Fatalf("expected an unknown mean time to revocation, got %s", m)
+	}
+	// never revoked
+	if m := newRevocations(trace, 10).mean(); m != 8*time.Hour {
+		t.Fatalf("expected the time alive as the lower bound, got %s", m)
+	}
+}
@@ -0,0 +1,96 @@
+package schedular
+
+import (
+	"fmt"
+	"github.com/paddie/statedb"
+	"math"
+	"time"
+)
+
+// YoungDaly checkpoints at the optimal interval of Daly's higher-order
+// extension of Young's formula, from the expected write time of a
+// checkpoint and the mean time to revocation. The mean time to revocation
+// is learned from the price trace and the bid, and the price updates.
+type YoungDaly struct {
+	rev      *revocations
+	cost     time.Duration // expected write time of a checkpoint
+	interval time.Duration
+	lastCpt  time.Time
+	revoked  bool // the price rose above the bid
+}
+
+func NewYoungDaly() *YoungDaly {
+	return &YoungDaly{}
+}
+
+func (r *YoungDaly) Name() string {
+	return "YoungDaly"
+}
+
+func (r *YoungDaly) Train(trace []statedb.PricePoint, bid float64) error {
+	r.rev = newRevocations(trace, bid)
+	r.lastCpt = time.Now()
+	r.update()
+	return nil
+}
+
+func (r *YoungDaly) StatUpdate(stat statedb.Stat) error {
+	// also sent on registrations, so only the cost is refreshed
+	if c := stat.ExpWriteCheckpoint(); c > 0 {
+		r.cost = c
+		r.update()
+	}
+	return nil
+}
+
+func (r *YoungDaly) PriceUpdate(p float64, t time.Time) error {
+	count := r.rev.count
+	r.rev.add(p, t)
+	if r.rev.count > count {
+		fmt.Printf("<YoungDaly> %.4f exceeds the bid: checkpoint at next sync\n", p)
+		r.revoked = true
+	}
+	r.update()
+	return nil
+}
+
+func (r *YoungDaly) PointOfConsistency() (bool, error) {
+	// the interval is unknown before the first checkpoint,
+	// or if the price never stayed below the bid
+	if r.interval <= 0 || r.revoked || time.Since(r.lastCpt) >= r.interval {
+		r.revoked = false
+		r.lastCpt = time.Now()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *YoungDaly) Quit() error {
+	return nil
+}
+
+func (r *YoungDaly) update() {
+	interval := dalyInterval(r.cost, r.rev.mean())
+	if interval != r.interval {
+		fmt.Printf("<YoungDaly> checkpoint interval: %s\n", interval)
+	}
+	r.interval = interval
+}
+
+// Daly's optimal compute time between checkpoints of cost c, given
+// the mean time to failure m, or 0 if either is unknown:
+//
+//	τ = √(2cm)·[1 + ⅓√(c/2m) + ⅑(c/2m)] − c, for c < 2m
+//	τ = m, otherwise
+func dalyInterval(c, m time.Duration) time.Duration {
+	if c <= 0 || m <= 0 {
+		return 0
+	}
+	if c >= 2*m {
+		return m
+	}
+	cs, ms := c.Seconds(), m.Seconds()
+	x := cs / (2 * ms)
+	tau := math.Sqrt(2*cs*ms)*(1+math.Sqrt(x)/3+x/9) - cs
+	return time.Duration(tau * float64(time.Second))
+}
@@ -0,0 +1,85 @@
+package schedular
+
+import (
+	"github.com/paddie/statedb"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDalyInterval(t *testing.T) {
+	if d := dalyInterval(0, time.Hour); d != 0 {
+		t.Fatalf("expected an unknown interval without a cost, got %s", d)
+	}
+	if d := dalyInterval(time.Minute, 0); d != 0 {
+		t.Fatalf("expected an unknown interval without a mean time to revocation, got %s", d)
+	}
+	if d := dalyInterval(3*time.Hour, time.Hour); d != time.Hour {
+		t.Fatalf("expected the mean time to revocation, got %s", d)
+	}
+
+	// close to Young's √(2cm) when c << m
+	c, m := time.Second, 24*time.Hour
+	young := time.Duration(math.Sqrt(2*c.Seconds()*m.Seconds()) * float64(time.Second))
+	if d := dalyInterval(c, m); math.Abs(float64(d-young)) > float64(2*time.Second) {
+		t.Fatalf("expected about %s, got %s", young, d)
+	}
+	// c = 1m, m = 2h: √864000·(1 + ⅓√(1/240) + ⅑/240) − 60 = 889.95s
+	if d := dalyInterval(time.Minute, 2*time.Hour); d.Seconds() < 889.9 || d.Seconds() > 890 {
+		t.Fatalf("expected 889.95s, got %s", d)
+	}
+}
+
+func TestYoungDaly(t *testing.T) {
+	start := time.Now().Add(-10 * time.Hour)
+	trace := syntheticTrace(start, time.Hour, 0.5, 0.5, 1.5, 0.5, 0.5, 0.5, 0.5, 2.0, 0.5)
+
+	r := NewYoungDaly()
+	if err := r.Train(trace, 1.0); err != nil {
+		t.Fatal(err)
+	}
+	// without an estimate of the cost, always checkpoint
+	if cpt, _ := r.PointOfConsistency(); !cpt {
+		t.Fatal("expected a checkpoint before the first stat")
+	}
+
+	// mean time to revocation 3h
+	r.cost = time.Minute
+	r.update()
+	if want := dalyInterval(time.Minute, 3*time.Hour); r.interval != want {
+		t.Fatalf("expected the interval %s, got %s", want, r.interval)
+	}
+	r.lastCpt = time.Now()
+	if cpt, _ := r.PointOfConsistency(); cpt {
+		t.Fatal("expected no checkpoint within the interval")
+	}
+	r.lastCpt = time.Now().Add(-r.interval)
+	if cpt, _ := r.PointOfConsistency(); !cpt {
+		t.Fatal("expected a checkpoint after the interval")
+	}
+
+	// stats sent between the syncs do not hold the checkpoint back
+	r.lastCpt = time.Now().Add(-r.interval / 2)
+	r.StatUpdate(statedb.Stat{})
+	if cpt, _ := r.PointOfConsistency(); cpt {
+		t.Fatal("expected no checkpoint within the interval")
+	}
+	r.lastCpt = r.lastCpt.Add(-r.interval / 2)
+	r.StatUpdate(statedb.Stat{})
+	if cpt, _ := r.PointOfConsistency(); !cpt {
+		t.Fatal("expected a checkpoint after the interval, despite the stats")
+	}
+
+	// a revocation shortens the interval, and checkpoints once
+	before := r.interval
+	r.PriceUpdate(1.5, start.Add(9*time.Hour))
+	if r.interval >= before {
+		t.Fatalf("expected a shorter interval than %s, got %s", before, r.interval)
+	}
+	if cpt, _ := r.PointOfConsistency(); !cpt {
+		t.Fatal("expected a checkpoint once the price exceeds the bid")
+	}
+	if cpt, _ := r.PointOfConsistency(); cpt {
+		t.Fatal("expected a single checkpoint for the revocation")
+	}
+}
@@ -14,6 +14,9 @@ type Stat struct {
 	t_r            time.Duration // time to restore from previous cpt
 	i, m, d_i, d_m int64         // size of each database
 	phi_i, phi_m   time.Duration // avg. cpt time pr. database
+	// durability of the last checkpoint
+	t_ld, t_rd time.Duration // time until durable on the local and remote tier
+	t_lag      time.Duration // time between the local and remote context write
 	// wall-clock datetime for events
 	lastConsistent time.Time
 	avgConsistent  *avgbuffer.AVGDuration
@@ -135,6 +138,36 @@ func (s *Stat) deltaCPT(m_dur, d_dur time.Duration) {
 	s.phi_m = time.Duration(float64(s.t_m) / float64(s.m))
 }
 
+// Update stats once the context has been written to the (local) Persistence
+func (s *Stat) localDurable(dur time.Duration) {
+	s.t_ld = dur
+}
+
+// Update stats once a TieredPersistence has replicated
+// the context to the remote tier
+func (s *Stat) remoteDurable(dur, lag time.Duration) {
+	s.t_rd = dur
+	s.t_lag = lag
+}
+
+// Time from the commit started until the last checkpoint
+// was durable on the local tier (or the only tier)
+func (s *Stat) LocalDurability() time.Duration {
+	return s.t_ld
+}
+
+// Time spent writing the last replicated checkpoint
+// to the remote tier of a TieredPersistence
+func (s *Stat) RemoteDurability() time.Duration {
+	return s.t_rd
+}
+
+// Time between the local and the remote write of the
+// last replicated context
+func (s *Stat) ReplicationLag() time.Duration {
+	return s.t_lag
+}
+
 // Time to restore from the precious checkpoint
 func (s *Stat) RestoreTime() time.Duration {
 	return s.t_r
@@ -172,6 +205,15 @@ func (s *Stat) expWriteZero() time.Duration {
 	return s.t_i + s.t_m + time.Duration(s.d_i)*s.phi_i + time.Duration(s.d_m)*s.phi_m
 }
 
+// Expected writing time of a checkpoint of the type,
+// or -1 before the first zero-checkpoint was committed
+func (s *Stat) expWrite(cptType int) time.Duration {
+	if cptType == DELTACPT {
+		return s.expWriteDelta()
+	}
+	return s.expWriteZero()
+}
+
 func (s *Stat) ExpReadCheckpoint() time.Duration {
 	if s.d_i > 0 {
 		return s.expReadZero()
@@ -4,7 +4,7 @@ import (
 	// "bytes"
 	"fmt"
 	// "reflect"
-	// "time"
+	"time"
 	// "strconv"
 	// "encoding/gob"
 	"errors"
@@ -34,9 +34,27 @@ var (
 
 type StateDB struct {
 	// fs       Persistence
-	restored bool     // has statedb just been restored
-	ready    bool     // have all mutable objects been restored?
-	ctx      *Context // cpt and restore information
+	restored bool      // has statedb just been restored
+	readOnly bool      // opened by OpenReadOnly, without a stateLoop
+	ready    bool      // have all mutable objects been restored?
+	readyErr error     // sticky error of the UnrestoredFail policy
+	opened   time.Time // start of the UnrestoredBlock timeout
+	ctx      *Context  // cpt and restore information
+	opts     *Options
+	fs       Persistence
+	loaded   chan bool // closed once a lazy restore has loaded the checkpoint
+	loadErr  error
+	lazy     lazyTypes       // immutable types not yet retrieved by a lazy restore
+	chunks   map[string]bool // chunks of the current content-addressed reference checkpoint
+	walSeq   int             // the last operation logged to the WAL
+	part     *participation  // set if the database is Coordinated, owned by the stateLoop
+	token    int             // fencing token of the lease, see fence.go
+	fenced   error           // LostOwnershipError once superseded, owned by the stateLoop
+	rollback *Context        // the context before the active commit, owned by the stateLoop
+	retry    bool            // the delta of a failed commit is lost, so the next checkpoint is a zero checkpoint
+	closing  bool            // Close refuses new syncs, owned by the stateLoop
+	closer   sync.Once
+	closeErr error
 	// State databases
 	immutable ImmKeyTypeMap // immutable states
 	delta     DeltaTypeMap  // static state delta
@@ -48,7 +66,12 @@ type StateDB struct {
 	quit         chan chan error // shutdown signals
 	sync_chan    chan *msg       // consistent state signals are sent on this channel
 	init_chan    chan chan error
-	sync.RWMutex // for synchronizing things that don't need the channels..
+	query_chan   chan *query        // functions run on the stateLoop
+	drain_chan   chan chan bool     // see Close
+	stopped      chan bool          // closed once the stateLoop has returned
+	subs         []*Subscription    // owned by the stateLoop
+	repl_chan    <-chan *replicated // replication events from a TieredPersistence
+	sync.RWMutex                    // for synchronizing things that don't need the channels..
 	// tl           *TimeLine
 }
 
@@ -56,29 +79,27 @@ type StateDB struct {
 // 	return db.restored
 // }
 
-func (db *StateDB) readyCheckpoint() bool {
-
-	if !db.restored {
-		return true
-	}
-
-	for _, vt := range db.mutable {
-		for _, vs := range vt {
-			if !vs.v.IsValid() {
-				fmt.Printf("Not valid: %#v", *vs)
-				return false
-			}
-		}
-	}
-	// every mutable object has been restored
-	// db.ready = true
-	return true
+func NewStateDB(fs Persistence, model Model, monitor Monitor, bid float64, path string) (*StateDB, bool, error) {
+	return NewStateDBWithOptions(fs, model, monitor, bid, path, nil)
 }
 
-func NewStateDB(fs Persistence, model Model, monitor Monitor, bid float64, path string) (*StateDB, bool, error) {
+func NewStateDBWithOptions(fs Persistence, model Model, monitor Monitor, bid float64, path string, opts *Options) (*StateDB, bool, error) {
 
 	// Initialize the directories
-	db, err := restore(fs)
+	var db *StateDB
+	var err error
+	if opts.lazy() {
+		db, err = restoreLazy(fs, opts)
+	} else {
+		db, err = restore(fs, opts)
+	}
+	// the WAL applies to the restored checkpoint, or to
+	// an empty database if no checkpoint was committed
+	replay := err == nil || err == NoCheckpointError
+	// a participant must never restart from another checkpoint
+	if !replay && opts.coordinated() {
+		return nil, false, err
+	}
 	if db == nil || err != nil {
 		db = &StateDB{
 			immutable: make(ImmKeyTypeMap),
@@ -88,10 +109,50 @@ func NewStateDB(fs Persistence, model Model, monitor Monitor, bid float64, path
 		}
 	}
 
+	return db.start(fs, model, monitor, bid, path, opts, replay)
+}
+
+// Starts the goroutines of a restored or empty database. If replay is set,
+// the operations logged to the WAL after its checkpoint are applied.
+func (db *StateDB) start(fs Persistence, model Model, monitor Monitor, bid float64, path string, opts *Options, replay bool) (*StateDB, bool, error) {
+
+	db.opts = opts
+	db.fs = fs
 	db.sync_chan = make(chan *msg)
 	db.op_chan = make(chan *stateOperation)
 	db.quit = make(chan chan error)
 	db.init_chan = make(chan chan error)
+	db.query_chan = make(chan *query)
+	db.drain_chan = make(chan chan bool)
+	db.stopped = make(chan bool)
+	db.opened = time.Now()
+	// before anything is written to fs
+	if opts.fencing() {
+		token, err := acquireLease(fs, opts.owner())
+		if err != nil {
+			return nil, false, err
+		}
+		db.token = token
+	}
+	if opts.coordinated() {
+		db.part = newParticipation(db)
+	}
+	// a lazy restore replays the WAL once it has loaded the checkpoint
+	if replay && db.loaded == nil {
+		if _, err := db.replayWAL(); err != nil {
+			return nil, false, err
+		}
+	} else if !replay {
+		if err := db.skipWAL(); err != nil {
+			return nil, false, err
+		}
+	}
+	db.ready = !db.restored
+
+	// report remote durability to the model
+	if t, ok := fs.(*TieredPersistence); ok {
+		db.repl_chan = t.events
+	}
 
 	timeline = NewTimeLine()
 
@@ -101,17 +162,13 @@ func NewStateDB(fs Persistence, model Model, monitor Monitor, bid float64, path
 	mnx := NewModelNexus()
 	go educate(model, monitor, mnx, bid)
 
-	go stateLoop(db, mnx, cnx, path)
-	return db, db.restored, nil
-}
-
-func (db *StateDB) Types() []string {
-
-	var ts []string
-	for t, _ := range db.immutable {
-		ts = append(ts, t)
+	restored := db.restored
+	if db.loaded != nil {
+		go db.loadLazy()
 	}
-	return ts
+
+	go stateLoop(db, mnx, cnx, path)
+	return db, restored, nil
 }
 
 // // Sync is a call for consistency; if the monitor has signalled a checkpoint
@@ -245,13 +302,13 @@ func (db *StateDB) Types() []string {
 // }
 
 // called from stateLoop to guarantee there is no race condition
-func (db *StateDB) insert(kt *KeyType, imm []byte, mut *MutState) error {
+func (db *StateDB) insert(kt *KeyType, imm []byte, ver int, mut *MutState) error {
 
 	if db.immutable.contains(kt) {
 		return errors.New("KeyType " + kt.String() + " already exists")
 	}
 
-	db.insertImmutable(kt, imm)
+	db.insertImmutable(kt, imm, ver)
 
 	if mut != nil {
 		db.insertMutable(kt, mut)
@@ -278,23 +335,23 @@ func (db *StateDB) remove(kt *KeyType) error {
 	return nil
 }
 
-func (db *StateDB) insertImmutable(kt *KeyType, val []byte) {
+func (db *StateDB) insertImmutable(kt *KeyType, val []byte, ver int) {
 
 	if db.immutable == nil {
 		db.immutable = make(ImmKeyTypeMap)
 	}
 
-	db.immutable.insert(kt, val)
-	db.insertDelta(kt, val)
+	db.immutable.insert(kt, val, ver)
+	db.insertDelta(kt, val, ver)
 }
 
 // Do we need a check for existense, or is that already made?
-func (db *StateDB) insertDelta(kt *KeyType, val []byte) {
+func (db *StateDB) insertDelta(kt *KeyType, val []byte, ver int) {
 
 	if db.delta == nil {
 		db.delta = make(DeltaTypeMap)
 	}
-	db.delta.insert(kt, val)
+	db.delta.insert(kt, val, ver)
 }
 
 func (db *StateDB) insertMutable(kt *KeyType, mut *MutState) {
@@ -1,6 +1,7 @@
 package statedb
 
 import (
+	"errors"
 	"fmt"
 	// "github.com/paddie/goamz/ec2"
 	// "github.com/paddie/statedb/monitor"
@@ -16,6 +17,10 @@ type msg struct {
 	forceCPT bool
 	t        *CheckpointTrace
 	waitChan chan error
+	final    bool      // the final checkpoint of Close
+	deadline time.Time // pick the checkpoint that is written before it
+	strict   bool      // refuse with DeadlineError if none is expected to be
+	cheapest bool      // budget the cheapest checkpoint, see budgetCheckpoint
 }
 
 type CheckpointQuery struct {
@@ -31,6 +36,9 @@ func errorPanic(errchan chan error) {
 }
 
 func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
+	defer close(db.stopped)
+	defer db.closeSubscriptions()
+
 	// Global error handing channel
 	// - every error on this channel results in a panic
 	errChan := make(chan error)
@@ -47,15 +55,18 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 	// this variable is true during a commit
 	active_commit := false
 
+	// a lazy restore has to finish before any operation
+	// or sync can be handled
+	db.waitLoaded()
+
 	// if the database was restored, one first needs to
 	// restore all the mutable entries before we can
-	// start encoding the new states.
-	// 1) call db.Init() to run the check to see if all states
-	//    have been restored prior to a run.
-	ready := !db.restored
+	// start encoding the new states (see db.Ready()).
 	// quit := false
 
 	waitChans := []chan error{}
+	// closed once the active commit returns, see Close
+	drainChans := []chan bool{}
 
 	for {
 		select {
@@ -64,13 +75,38 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 			// ignore this sync
 			stat.markConsistent()
 
-			// is only checked once, to make sure that
-			// the mutable states have all been updated
+			// only the final checkpoint follows a Close
+			if db.closing && !m.final {
+				m.err <- ClosedError
+				m.t.Abort()
+				continue
+			}
+
+			// nor does one whose lazy restore failed, which
+			// would overwrite the checkpoint it restores
+			if db.loadErr != nil {
+				m.err <- db.loadErr
+				m.t.Abort()
+				continue
+			}
+
+			// a superseded instance no longer checkpoints
+			if db.fenced != nil {
+				m.err <- db.fenced
+				m.t.Abort()
+				continue
+			}
+
+			// is only checked until it succeeds, to make sure
+			// that the mutable states have all been updated
 			// with new pointers.
-			if !ready {
-				ready = db.readyCheckpoint()
-				if !ready {
-					m.err <- NotRestoredError
+			if !db.ready {
+				if err := db.checkReady(stat); err != nil {
+					if db.blockSync() {
+						go db.awaitReady(m)
+						continue
+					}
+					m.err <- err
 					continue
 				}
 			}
@@ -87,11 +123,22 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 			// stat trace for the timeline
 			t := m.t
 
+			// a participant only checkpoints
+			// when a global checkpoint is prepared
+			gid, cptType := 0, m.cptType
+			if db.part != nil {
+				var ok bool
+				if gid, cptType, ok = db.part.serves(m); !ok {
+					t.Abort()
+					continue
+				}
+			}
+
 			// forceCPT is used for testing
 			// so we only query the model if
 			// that particular flag is not set
 			t.ModelStart()
-			if !m.forceCPT {
+			if !m.forceCPT && gid == 0 {
 				mnx.cptQueryChan <- cptQ
 				if cpt := <-cptQ.cptChan; !cpt {
 					m.err <- nil
@@ -102,16 +149,40 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 			}
 			t.ModelEnd()
 
-			// TODO: possibly decide what type of checkpoint to encode
+			// a commit retried after a failed read of the lease is a
+			// zero checkpoint, and is budgeted as one. A prepared
+			// global checkpoint takes its own type
+			if db.retry && gid == 0 {
+				cptType = ZEROCPT
+			}
+			if !m.deadline.IsZero() && gid == 0 {
+				var fits bool
+				window := m.deadline.Sub(time.Now())
+				if cptType, fits = db.budgetCheckpoint(stat, window, m.cheapest); !fits {
+					if m.strict {
+						m.err <- DeadlineError
+						t.Abort()
+						continue
+					}
+					fmt.Printf("StateDB: no checkpoint is expected to be written within %s\n", window)
+				}
+			}
 
 			// encode checkpoint
 			t.EncodingStart()
-			req, err := db.encodeCheckpoint(m.cptType, stat)
+			req, err := db.encodeCheckpoint(cptType, stat)
 			if err != nil {
+				if gid > 0 {
+					db.part.fail(err)
+				}
 				// do not report error if there is nothing
 				// to checkpoint
 				if err == NoDataError {
 					m.err <- nil
+					// nothing will be committed
+					if m.waitChan != nil {
+						m.waitChan <- nil
+					}
 				} else {
 					m.err <- err
 					// report global error
@@ -125,6 +196,14 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 
 			// state was successfully encoded, return control to application while performing commit
 			active_commit = true
+			// every operation logged so far is in the checkpoint
+			req.ctx.WALSeq = db.walSeq
+			req.ctx.GID = gid
+			req.ctx.Token = db.token
+			if gid > 0 {
+				req.prepare = true
+				db.part.encoded()
+			}
 
 			if m.waitChan != nil {
 				fmt.Println("received a commit checkpoint")
@@ -133,6 +212,7 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 
 			cnx.comReqChan <- req
 			m.err <- nil
+			db.publish(EventCheckpointEncoded, nil, req.ctx, nil)
 
 			// forward the encoded state to be committed
 			// and signal an active commit
@@ -143,13 +223,23 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 			db.delta = nil
 			// 2) update the context to reflect the
 			//    type of checkpoint that was encoded
+			db.rollback = db.ctx.Copy()
+			db.retry = false
 			*db.ctx = *req.ctx
 		case r := <-cnx.comRespChan:
 			// no active commits anymore
 			active_commit = false
+			for _, dc := range drainChans {
+				close(dc)
+			}
+			drainChans = nil
 			// if the commit failed,
 			// report the event on the errChan
 			if !r.Success() {
+				if r.prepare {
+					db.part.fail(r.Err())
+				}
+				db.publish(EventCommitFailed, nil, r.ctx, r.Err())
 				if len(waitChans) > 0 {
 					for _, wc := range waitChans {
 						wc <- r.Err()
@@ -159,9 +249,34 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 					// next time
 					waitChans = nil
 				}
+				// stop checkpointing rather than the application
+				if r.ctx_err == LostOwnershipError {
+					fmt.Println("StateDB:", r.ctx_err)
+					db.fenced = r.ctx_err
+					continue
+				}
+				// retried at the next checkpoint, see revert
+				// for a prepared global checkpoint
+				if errors.Is(r.ctx_err, LeaseUnavailableError) {
+					fmt.Println("StateDB:", r.ctx_err)
+					if !r.prepare {
+						*db.ctx = *db.rollback
+						db.retry = true
+					}
+					continue
+				}
 				errChan <- r.Err()
 				continue
 			}
+			if r.prepare {
+				// published once the coordinator commits
+				db.holdPrepared(r)
+			} else {
+				db.publish(EventCheckpointCommitted, nil, r.ctx, nil)
+				if wal := db.opts.wal(); wal != nil {
+					go truncateWAL(wal, r.ctx.WALSeq)
+				}
+			}
 			// signal to any waiting process that
 			// the write was completed.
 			if len(waitChans) > 0 {
@@ -180,15 +295,46 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 			} else {
 				stat.zeroCPT(r.imm_dur, r.mut_dur)
 			}
+			stat.localDurable(r.dur)
+			// the chunks of the new reference checkpoint are
+			// the ones the next zero checkpoint can skip
+			if r.cpt_type == ZEROCPT && !r.prepare {
+				db.chunks = r.refs
+			}
 			// send copy of updated stat to model
 			mnx.statChan <- *stat
+		case rs := <-db.repl_chan:
+			// a replication failure leaves the remote tier at
+			// an older, but valid, checkpoint
+			if rs.err != nil {
+				fmt.Println(rs.err)
+				continue
+			}
+			stat.remoteDurable(rs.dur, rs.lag)
+			mnx.statChan <- *stat
+		case q := <-db.query_chan:
+			q.fn()
+			close(q.done)
 		case so := <-db.op_chan:
-			if !ready {
-				so.err <- NotRestoredError
+			if db.loadErr != nil {
+				so.err <- db.loadErr
+				continue
+			}
+			if !db.ready {
+				if kts := db.unrestored(); len(kts) > 0 {
+					so.err <- db.unrestoredError(kts)
+					continue
+				}
+				db.ready = true
 			}
 			// Insert or Remove entries in the database
 			kt := so.kt
 
+			if err := db.logOperation(so); err != nil {
+				so.err <- err
+				continue
+			}
+
 			switch so.action {
 			case REMOVE:
 				err := db.remove(kt)
@@ -198,18 +344,20 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 				}
 				// reply success
 				so.err <- nil
+				db.publish(EventRemoved, kt, nil, nil)
 				// Update and send stat
 				stat.remove(1, 1)
 				mnx.statChan <- *stat
 				continue
 			case INSERT:
-				err := db.insert(kt, so.imm, so.mut)
+				err := db.insert(kt, so.imm, so.ver, so.mut)
 				if err != nil {
 					so.err <- err
 					continue
 				}
 				// reply success
 				so.err <- nil
+				db.publish(EventInserted, kt, nil, nil)
 
 				// Update and ship stat
 				stat.insert(1, 1)
@@ -220,21 +368,38 @@ func stateLoop(db *StateDB, mnx *ModelNexus, cnx *CommitNexus, path string) {
 			// it is a fatal error
 			so.err <- UnknownOperation
 			errChan <- UnknownOperation
+		case dc := <-db.drain_chan:
+			db.closing = true
+			if active_commit {
+				drainChans = append(drainChans, dc)
+			} else {
+				close(dc)
+			}
 		case respChan := <-db.quit:
-			// if an active commit is ongoing
-			// TODO: set notify channel
-			//       which will be checked on a completed commit
-			mnx.Quit()
+			var errs []error
+			if err := mnx.Quit(); err != nil {
+				errs = append(errs, err)
+			}
+			// the committer returns once the active commit,
+			// if any, has been written
 			cnx.Quit()
+			for r := range cnx.comRespChan {
+				var err error
+				if !r.Success() {
+					err = r.Err()
+					errs = append(errs, err)
+				}
+				for _, wc := range waitChans {
+					wc <- err
+				}
+				waitChans = nil
+			}
 			if path != "" {
-				err := timeline.Write(path)
-				if err != nil {
-					respChan <- err
-					errChan <- err
-					return
+				if err := timeline.Write(path); err != nil {
+					errs = append(errs, err)
 				}
 			}
-			respChan <- nil
+			respChan <- errors.Join(errs...)
 			return
 		}
 	}
@@ -0,0 +1,76 @@
+package statedb
+
+import (
+	"testing"
+	"time"
+)
+
+// Model that checkpoints at every point of consistency
+type stubModel struct{}
+
+func (m *stubModel) Name() string                             { return "stub" }
+func (m *stubModel) Train(_ []PricePoint, _ float64) error    { return nil }
+func (m *stubModel) PriceUpdate(_ float64, _ time.Time) error { return nil }
+func (m *stubModel) StatUpdate(_ Stat) error                  { return nil }
+func (m *stubModel) PointOfConsistency() (bool, error)        { return true, nil }
+func (m *stubModel) Quit() error                              { return nil }
+
+// Monitor without a price trace
+type stubMonitor struct{}
+
+func (m *stubMonitor) Trace() ([]PricePoint, error)                    { return nil, nil }
+func (m *stubMonitor) Start(_ chan<- PricePoint, _ chan<- error) error { return nil }
+func (m *stubMonitor) Stop()                                           {}
+func (m *stubMonitor) Active() bool                                    { return true }
+func (m *stubMonitor) Name() string                                    { return "stub" }
+
+type entMut struct {
+	Pos int
+}
+
+type ent struct {
+	ID   int
+	Name string
+	m    entMut
+}
+
+func (e *ent) Mutable() interface{} {
+	return &e.m
+}
+
+func openTestDB(t *testing.T, fs Persistence, opts *Options) (*StateDB, bool) {
+	db, restored, err := NewStateDBWithOptions(fs, &stubModel{}, &stubMonitor{}, 1.0, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, restored
+}
+
+func registerEnts(t *testing.T, db *StateDB, ents ...*ent) {
+	for _, e := range ents {
+		if _, err := db.Register(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Forces a checkpoint of the type and waits for it to be committed
+func commitBlock(t *testing.T, db *StateDB, cptType int) {
+	errChan := make(chan error)
+	waitChan := make(chan error, 1)
+	c := timeline.Tick()
+	db.sync_chan <- &msg{
+		time:     time.Now(),
+		err:      errChan,
+		forceCPT: true,
+		cptType:  cptType,
+		t:        c,
+		waitChan: waitChan,
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waitChan; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+package statedb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// time left before the termination once the final
+// checkpoint should have been written, see HandleTermination
+const DefaultTerminationMargin = 10 * time.Second
+
+// TerminationWatcher reports the revocation of the instance, such as the
+// two-minute termination notice of a spot instance. Watch delivers the
+// time of the termination once a notice is posted, and nothing after
+// stop is closed. See monitor/termination for the instance metadata of EC2.
+type TerminationWatcher interface {
+	Watch(stop <-chan bool) <-chan time.Time
+}
+
+// HandleTermination watches for a termination notice until the returned
+// function is called or the database is shut down. On a notice, it
+// publishes EventTerminationNotice and closes the database like Close,
+// with the cheapest checkpoint that is expected to be written margin
+// before the termination as the final checkpoint. A margin <= 0 defaults
+// to DefaultTerminationMargin.
+func (db *StateDB) HandleTermination(w TerminationWatcher, margin time.Duration) func() {
+	if margin <= 0 {
+		margin = DefaultTerminationMargin
+	}
+	stop := make(chan bool)
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(stop)
+		})
+	}
+
+	notices := w.Watch(stop)
+	go func() {
+		defer cancel()
+		select {
+		case at, ok := <-notices:
+			if !ok {
+				return
+			}
+			// a late notice leaves less than the margin
+			deadline := at.Add(-margin)
+			if !deadline.After(time.Now()) {
+				deadline = at
+			}
+			db.terminate(deadline)
+		case <-stop:
+		case <-db.stopped:
+		}
+	}()
+	return cancel
+}
+
+// Takes the final checkpoint within the deadline and shuts down
+func (db *StateDB) terminate(deadline time.Time) {
+	// nothing runs in a read-only database
+	if db.readOnly {
+		return
+	}
+	fmt.Printf("StateDB: termination notice, checkpointing within %s\n", deadline.Sub(time.Now()))
+	db.serve(func() {
+		db.publish(EventTerminationNotice, nil, nil, nil)
+	})
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	db.closer.Do(func() {
+		db.closeErr = db.close(ctx, NONDETERMCPT, true)
+	})
+	if db.closeErr != nil {
+		fmt.Println("StateDB: termination:", db.closeErr)
+	}
+}
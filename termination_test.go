@@ -0,0 +1,47 @@
+package statedb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Delivers the notices sent on the channel
+type chanWatcher chan time.Time
+
+func (w chanWatcher) Watch(stop <-chan bool) <-chan time.Time {
+	return w
+}
+
+func TestHandleTermination(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db, &ent{ID: 1})
+	commitBlock(t, db, ZEROCPT)
+	// NONDETERMCPT would choose a zero checkpoint
+	registerEnts(t, db, &ent{ID: 2})
+
+	sub := db.Subscribe(&EventFilter{Kinds: []int{EventTerminationNotice}})
+	w := make(chanWatcher, 1)
+	db.HandleTermination(w, time.Second)
+
+	w <- time.Now().Add(time.Minute)
+	select {
+	case <-sub.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no termination notice was published")
+	}
+	select {
+	case <-db.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the database was not shut down")
+	}
+
+	// the delta checkpoint is the cheapest
+	if ctx, _ := retrieveContext(fs); ctx.ID() != "1.2" {
+		t.Fatalf("expected the final delta checkpoint 1.2, got %s", ctx.ID())
+	}
+	if err := db.Close(context.Background(), NOCPT); err != nil {
+		t.Fatal(err)
+	}
+}
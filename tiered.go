@@ -0,0 +1,335 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	replicationRetries = 3
+	replicationBackoff = 100 * time.Millisecond
+)
+
+var (
+	TieredClosedError = errors.New("TieredPersistence has been closed")
+)
+
+// A file waiting to be replicated to the remote tier
+type replication struct {
+	name   string
+	data   []byte
+	del    bool
+	queued time.Time // when the local write returned
+}
+
+// Reported to stateLoop every time a context file has been
+// replicated (or failed to replicate) to the remote tier
+type replicated struct {
+	dur time.Duration // total remote write time of the checkpoint
+	lag time.Duration // time between the local and the remote context write
+	err error
+}
+
+// TieredPersistence writes every file to a fast local Persistence and
+// returns, while a single background replicator uploads the files to a
+// remote Persistence in the order they were written.
+//
+// A context file is only replicated if every file written before it
+// made it to the remote tier, so the remote tier always holds a valid,
+// but possibly older, checkpoint.
+type TieredPersistence struct {
+	local, remote Persistence
+	queue         []*replication
+	cond          *sync.Cond
+	active        bool // replicator is uploading an item
+	closed        bool
+	done          chan bool
+	failed        bool          // a file of the pending checkpoint failed
+	poison        int           // RCID with a missing delta or reference checkpoint on the remote tier
+	pending       time.Duration // remote write time since the last context
+	err           error         // most recent replication error
+	events        chan *replicated
+	sync.Mutex
+}
+
+func NewTieredPersistence(local, remote Persistence) (*TieredPersistence, error) {
+	if local == nil || remote == nil {
+		return nil, errors.New("TieredPersistence: local and remote tiers cannot be <nil>")
+	}
+
+	t := &TieredPersistence{
+		local:  local,
+		remote: remote,
+		done:   make(chan bool),
+		events: make(chan *replicated, 16),
+	}
+	t.cond = sync.NewCond(&t.Mutex)
+
+	go t.replicate()
+
+	return t, nil
+}
+
+func (t *TieredPersistence) Init() error {
+	if err := t.local.Init(); err != nil {
+		return err
+	}
+	return t.remote.Init()
+}
+
+// Put returns as soon as the file is written to the local tier
+func (t *TieredPersistence) Put(name string, data []byte) error {
+	if err := t.local.Put(name, data); err != nil {
+		return err
+	}
+	return t.enqueue(&replication{
+		name:   name,
+		data:   data,
+		queued: time.Now(),
+	})
+}
+
+// Get prefers the local tier. For the context files both tiers are
+// queried and the most recent valid context is returned.
+func (t *TieredPersistence) Get(name string) ([]byte, error) {
+	if isContextPath(name) {
+		return t.getContext(name)
+	}
+
+	data, err := t.local.Get(name)
+	if err == nil {
+		return data, nil
+	}
+	return t.remote.Get(name)
+}
+
+func (t *TieredPersistence) getContext(name string) ([]byte, error) {
+	ldata, lerr := t.local.Get(name)
+	rdata, rerr := t.remote.Get(name)
+
+	var lctx, rctx *Context
+	if lerr == nil {
+		lctx, lerr = decodeContext(ldata)
+	}
+	if rerr == nil {
+		rctx, rerr = decodeContext(rdata)
+	}
+
+	switch {
+	case lerr != nil && rerr != nil:
+		return nil, lerr
+	case lerr != nil:
+		return rdata, nil
+	case rerr != nil:
+		return ldata, nil
+	}
+
+	if MostRecent(lctx, rctx) == lctx {
+		return ldata, nil
+	}
+	return rdata, nil
+}
+
+func (t *TieredPersistence) Delete(name string) error {
+	if err := t.local.Delete(name); err != nil {
+		return err
+	}
+	return t.enqueue(&replication{
+		name:   name,
+		del:    true,
+		queued: time.Now(),
+	})
+}
+
+// List returns the union of the files on both tiers
+func (t *TieredPersistence) List(prefix string) ([]string, error) {
+	ls, lerr := t.local.List(prefix)
+	rs, rerr := t.remote.List(prefix)
+	if lerr != nil && rerr != nil {
+		return nil, lerr
+	}
+
+	seen := make(map[string]bool, len(ls)+len(rs))
+	items := make([]string, 0, len(ls)+len(rs))
+	for _, l := range append(ls, rs...) {
+		if seen[l] {
+			continue
+		}
+		seen[l] = true
+		items = append(items, l)
+	}
+	return items, nil
+}
+
+// Flush blocks until every queued file has been replicated
+func (t *TieredPersistence) Flush() error {
+	t.Lock()
+	defer t.Unlock()
+	for len(t.queue) > 0 || t.active {
+		t.cond.Wait()
+	}
+	return t.err
+}
+
+// Close replicates the remaining files and stops the replicator
+func (t *TieredPersistence) Close() error {
+	t.Lock()
+	if t.closed {
+		t.Unlock()
+		return TieredClosedError
+	}
+	t.closed = true
+	t.cond.Broadcast()
+	t.Unlock()
+
+	<-t.done
+
+	t.Lock()
+	defer t.Unlock()
+	return t.err
+}
+
+// Returns the most recent replication error
+func (t *TieredPersistence) Err() error {
+	t.Lock()
+	defer t.Unlock()
+	return t.err
+}
+
+// Number of files waiting to be replicated
+func (t *TieredPersistence) Pending() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.queue)
+}
+
+func (t *TieredPersistence) enqueue(r *replication) error {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return TieredClosedError
+	}
+	t.queue = append(t.queue, r)
+	t.cond.Broadcast()
+	return nil
+}
+
+// replicate uploads the queued files one at a time,
+// until the persistence is closed and the queue is empty
+func (t *TieredPersistence) replicate() {
+	defer close(t.done)
+
+	for {
+		t.Lock()
+		for len(t.queue) == 0 && !t.closed {
+			t.cond.Wait()
+		}
+		if len(t.queue) == 0 && t.closed {
+			t.Unlock()
+			return
+		}
+		r := t.queue[0]
+		t.queue[0] = nil
+		t.queue = t.queue[1:]
+		t.active = true
+		t.Unlock()
+
+		if isContextPath(r.name) && !r.del {
+			t.replicateContext(r)
+		} else {
+			t.replicateFile(r)
+		}
+
+		t.Lock()
+		t.active = false
+		t.cond.Broadcast()
+		t.Unlock()
+	}
+}
+
+func (t *TieredPersistence) replicateFile(r *replication) {
+	dur, err := t.retry(r)
+	t.pending += dur
+	if err == nil {
+		return
+	}
+
+	t.setErr(err)
+	t.failed = true
+
+	// a missing delta invalidates every later
+	// context with the same reference checkpoint
+	var rcid int
+	var file string
+	if _, e := fmt.Sscanf(r.name, "%d/%s", &rcid, &file); e == nil {
+		if len(file) >= 3 && file[:3] == "del" {
+			t.poison = rcid
+		}
+	}
+}
+
+func (t *TieredPersistence) replicateContext(r *replication) {
+	defer func() {
+		t.failed = false
+		t.pending = 0
+	}()
+
+	ev := &replicated{}
+
+	ctx, err := decodeContext(r.data)
+	if err == nil && t.failed && ctx.Type == ZEROCPT {
+		// the reference checkpoint itself is incomplete
+		t.poison = ctx.RCID
+	}
+
+	if err != nil {
+		ev.err = err
+	} else if t.failed || (t.poison != 0 && ctx.RCID == t.poison) {
+		ev.err = fmt.Errorf("TieredPersistence: context %s not replicated, remote tier is missing checkpoint files", ctx.ID())
+	} else {
+		var dur time.Duration
+		dur, ev.err = t.retry(r)
+		ev.dur = t.pending + dur
+		ev.lag = time.Now().Sub(r.queued)
+		if ev.err == nil && t.poison != ctx.RCID {
+			t.poison = 0
+		}
+	}
+
+	if ev.err != nil {
+		t.setErr(ev.err)
+	}
+
+	// never block the replicator on a slow or absent listener
+	select {
+	case t.events <- ev:
+	default:
+	}
+}
+
+func (t *TieredPersistence) retry(r *replication) (time.Duration, error) {
+	var err error
+	var dur time.Duration
+	for i := 0; i < replicationRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * replicationBackoff)
+		}
+		if r.del {
+			err = t.remote.Delete(r.name)
+		} else {
+			dur, err = commit_t(t.remote, r.name, r.data)
+		}
+		if err == nil {
+			return dur, nil
+		}
+	}
+	return dur, err
+}
+
+func (t *TieredPersistence) setErr(err error) {
+	t.Lock()
+	t.err = err
+	t.Unlock()
+}
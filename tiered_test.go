@@ -0,0 +1,108 @@
+package statedb
+
+import (
+	"testing"
+)
+
+func putContext(t *testing.T, fs Persistence, ctx *Context) {
+	if err := commitContext(fs, ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTieredReplicatesInOrder(t *testing.T) {
+	local, remote := newMemFS(), newMemFS()
+	tp, err := NewTieredPersistence(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewContext().newZeroContext()
+	names := []string{ctx.ImmPath(), ctx.MutPath()}
+	for _, n := range names {
+		if err := tp.Put(n, []byte(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	putContext(t, tp, ctx)
+
+	if err := tp.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := append(names, ctx.CtxPath())
+	if len(remote.puts) != len(exp) {
+		t.Fatalf("remote received %v, expected %v", remote.puts, exp)
+	}
+	for i := range exp {
+		if remote.puts[i] != exp[i] {
+			t.Fatalf("remote received %v, expected %v", remote.puts, exp)
+		}
+	}
+
+	select {
+	case ev := <-tp.events:
+		if ev.err != nil {
+			t.Fatal(ev.err)
+		}
+	default:
+		t.Fatal("no replication event was reported")
+	}
+
+	if err := tp.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTieredSkipsContextOnFailure(t *testing.T) {
+	local, remote := newMemFS(), newMemFS()
+	tp, _ := NewTieredPersistence(local, remote)
+	defer tp.Close()
+
+	ctx := NewContext().newZeroContext()
+	remote.setFail(ctx.ImmPath(), true)
+
+	tp.Put(ctx.ImmPath(), []byte("imm"))
+	tp.Put(ctx.MutPath(), []byte("mut"))
+	putContext(t, tp, ctx)
+
+	if err := tp.Flush(); err == nil {
+		t.Fatal("expected a replication error")
+	}
+	if remote.has(ctx.CtxPath()) {
+		t.Fatal("context was replicated without its immutable checkpoint")
+	}
+
+	// a delta on top of the incomplete reference checkpoint
+	// must not be published either
+	dctx := ctx.newDeltaContext()
+	tp.Put(dctx.MutPath(), []byte("mut"))
+	putContext(t, tp, dctx)
+	tp.Flush()
+
+	if remote.has(dctx.CtxPath()) {
+		t.Fatal("delta context was replicated on top of an incomplete reference checkpoint")
+	}
+}
+
+func TestTieredPrefersMostRecentContext(t *testing.T) {
+	local, remote := newMemFS(), newMemFS()
+
+	old := NewContext().newZeroContext()
+	recent := old.newDeltaContext().newDeltaContext()
+
+	// the local disk holds an older checkpoint than the remote tier
+	putContext(t, local, old)
+	putContext(t, remote, recent)
+
+	tp, _ := NewTieredPersistence(local, remote)
+	defer tp.Close()
+
+	ctx, err := retrieveContext(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.ID() != recent.ID() {
+		t.Fatalf("restored context %s, expected %s", ctx.ID(), recent.ID())
+	}
+}
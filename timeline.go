@@ -48,6 +48,8 @@ func (tl *TimeLine) Commit(start time.Time) {
 
 	dur := time.Now().Sub(start)
 
+	tl.Lock()
+	defer tl.Unlock()
 	tl.CommitStarts = append(tl.CommitStarts, start.Sub(tl.Start))
 	tl.CommitDurations = append(tl.CommitDurations, dur)
 	tl.CmtCnt++
@@ -90,11 +92,12 @@ func (tl *TimeLine) Tick() *CheckpointTrace {
 	}
 
 	now := time.Now()
+	// several databases may sync at the same time
+	tl.Lock()
 	c := NewCheckpointTrace(tl, tl.Start)
 	// increase the cnt for the next tick..
 	tl.SyncCnt++
 
-	tl.Lock()
 	tl.events = append(tl.events, c)
 	tl.SyncStarts = append(tl.SyncStarts, now.Sub(tl.Start))
 	// placeholders
@@ -107,8 +110,8 @@ func (tl *TimeLine) Tick() *CheckpointTrace {
 }
 
 func (tl *TimeLine) Tock(c *CheckpointTrace) {
-	// tl.Lock()
-	// defer tl.Unlock()
+	tl.Lock()
+	defer tl.Unlock()
 	// tl.Starts[c.id] = c.Start
 	tl.SyncDurations[c.id] = c.SyncDuration
 	tl.MdlDurations[c.id] = c.MdlDuration
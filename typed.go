@@ -0,0 +1,97 @@
+package statedb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// TypedIterator iterates over the restored states of type T
+//
+//	it, err := statedb.Restore[Particle](db)
+//	for it.Next() {
+//		p := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type TypedIterator[T any] struct {
+	entries []*entry
+	i       int
+	val     *T
+	kt      *KeyType
+	err     error
+}
+
+// Restore returns an iterator over every restored state of type T. The
+// TypeID of T (see ReflectTypeM) must match the TypeID the states were
+// registered with.
+func Restore[T any](db *StateDB) (*TypedIterator[T], error) {
+
+	if db == nil {
+		return nil, errors.New("StateDB: database has not been initialized. Call NewStateDB(...)")
+	}
+
+	if t := reflect.TypeOf((*T)(nil)).Elem(); t.Kind() == reflect.Ptr {
+		return nil, fmt.Errorf("StateDB.Restore: type parameter must not be a pointer: %s", t.String())
+	}
+
+	typeID := ReflectTypeM(new(T))
+	entries, err := db.entries(typeID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedIterator[T]{entries: entries}, nil
+}
+
+// Next decodes the next state, which is then available through Value.
+// It returns false once every state has been restored, or on an error.
+func (it *TypedIterator[T]) Next() bool {
+
+	if it.err != nil || it.i >= len(it.entries) {
+		it.val, it.kt = nil, nil
+		return false
+	}
+	e := it.entries[it.i]
+	it.i++
+
+	val := new(T)
+	if err := e.restore(val); err != nil {
+		it.err = err
+		it.val, it.kt = nil, nil
+		return false
+	}
+	it.val, it.kt = val, e.kt
+
+	return true
+}
+
+// The state decoded by the last call to Next
+func (it *TypedIterator[T]) Value() *T {
+	return it.val
+}
+
+// The KeyType of the state decoded by the last call to Next
+func (it *TypedIterator[T]) KeyType() *KeyType {
+	return it.kt
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *TypedIterator[T]) Err() error {
+	return it.err
+}
+
+// Number of states the iterator restores
+func (it *TypedIterator[T]) Len() int {
+	return len(it.entries)
+}
+
+// All restores the remaining states into a slice
+func (it *TypedIterator[T]) All() ([]*T, error) {
+	all := make([]*T, 0, len(it.entries)-it.i)
+	for it.Next() {
+		all = append(all, it.Value())
+	}
+	return all, it.Err()
+}
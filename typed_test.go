@@ -0,0 +1,72 @@
+package statedb
+
+import (
+	"testing"
+)
+
+type other struct {
+	ID int
+}
+
+func TestTypedRestore(t *testing.T) {
+	fs := newMemFS()
+	db, _ := openTestDB(t, fs, nil)
+	registerEnts(t, db,
+		&ent{ID: 1, Name: "a", m: entMut{Pos: 10}},
+		&ent{ID: 2, Name: "b", m: entMut{Pos: 20}})
+	if err := db.forceZeroCPTBlock(); err != nil {
+		t.Fatal(err)
+	}
+	db.Quit()
+
+	restored, err := restore(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := Restore[ent](restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := it.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("restored %d states, expected 2", len(all))
+	}
+	for _, e := range all {
+		if e.m.Pos != e.ID*10 {
+			t.Errorf("mutable state of %d was not restored: %#v", e.ID, e.m)
+		}
+	}
+
+	if _, err := Restore[other](restored); err == nil {
+		t.Fatal("restored a type that was never registered")
+	}
+	if _, err := Restore[*ent](restored); err == nil {
+		t.Fatal("restored a pointer type parameter")
+	}
+}
+
+func TestTypedRestoreMissingMutable(t *testing.T) {
+	kt, _ := NewIntKeyType(1, ReflectType(&ent{}))
+	val, _ := encode(&ent{ID: 1})
+
+	db := &StateDB{
+		immutable: make(ImmKeyTypeMap),
+		mutable:   make(MutKeyTypeMap),
+	}
+	db.immutable.insert(kt, val, 0)
+
+	it, err := Restore[ent](db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if it.Next() {
+		t.Fatal("restored a state without its mutable part")
+	}
+	if it.Err() == nil {
+		t.Fatal("missing mutable state was not reported")
+	}
+}
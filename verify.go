@@ -0,0 +1,237 @@
+package statedb
+
+import (
+	"fmt"
+)
+
+// Kinds of Issue found by Verify
+const (
+	IssueMissing = "missing" // a referenced file does not exist
+	IssueCorrupt = "corrupt" // a file, or a chunk it references, does not decode
+	IssueReplay  = "replay"  // the deltas cannot be replayed onto the reference checkpoint
+	IssueOrphan  = "orphan"  // a mutable state has no immutable counterpart
+)
+
+// Issue is a problem with one of the files of a checkpoint
+type Issue struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+	KeyType string `json:"keytype,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (i *Issue) String() string {
+	s := i.Kind
+	if i.Path != "" {
+		s += " " + i.Path
+	}
+	if i.KeyType != "" {
+		s += " " + i.KeyType
+	}
+	if i.Detail != "" {
+		s += ": " + i.Detail
+	}
+	return s
+}
+
+// ContextReport is the result of verifying the checkpoint of a context
+type ContextReport struct {
+	Path      string         `json:"path"`
+	Ctx       *Context       `json:"context,omitempty"`
+	Latest    bool           `json:"latest"`
+	Files     map[string]int `json:"files"` // bytes of every file that was read
+	Immutable int            `json:"immutable"`
+	Mutable   int            `json:"mutable"`
+	Issues    []*Issue       `json:"issues,omitempty"`
+}
+
+func (r *ContextReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// VerifyReport is the result of Verify
+type VerifyReport struct {
+	Contexts []*ContextReport `json:"contexts"`
+}
+
+// OK is true if every retained checkpoint can be restored
+func (r *VerifyReport) OK() bool {
+	for _, c := range r.Contexts {
+		if !c.OK() {
+			return false
+		}
+	}
+	return len(r.Contexts) > 0
+}
+
+// Verify checks the checkpoint of every retained context: that every file
+// it references exists and decodes, that its deltas replay, and that every
+// mutable state has an immutable counterpart. A context file that does not
+// exist is not an issue, as only one exists until the second checkpoint,
+// but one that exists and cannot be read is.
+//
+// The error is only set if fs holds no context at all.
+func Verify(fs Persistence) (*VerifyReport, error) {
+
+	files := ReadContexts(fs)
+	latest := LatestContext(files)
+
+	report := &VerifyReport{}
+	exists := false
+	for _, cf := range files {
+		if cf.Missing {
+			continue
+		}
+		exists = true
+		cr := &ContextReport{
+			Path:  cf.Path,
+			Ctx:   cf.Ctx,
+			Files: map[string]int{},
+		}
+		if cf.Ctx == nil && cf.Size == 0 {
+			// exists, or could not be listed, but cannot be read
+			cr.issue(IssueMissing, cf.Path, nil, cf.Err)
+		} else if cf.Ctx == nil {
+			cr.Files[cf.Path] = cf.Size
+			cr.issue(IssueCorrupt, cf.Path, nil, cf.Err)
+		} else {
+			cr.Files[cf.Path] = cf.Size
+			cr.Latest = cf.Ctx == latest
+			cr.verify(fs, cf.Ctx)
+		}
+		report.Contexts = append(report.Contexts, cr)
+	}
+
+	if !exists {
+		return report, NoCheckpointError
+	}
+	return report, nil
+}
+
+func (cr *ContextReport) issue(kind, path string, kt *KeyType, err error) {
+	i := &Issue{
+		Kind: kind,
+		Path: path,
+	}
+	if kt != nil {
+		i.KeyType = kt.String()
+	}
+	if err != nil {
+		i.Detail = err.Error()
+	}
+	cr.Issues = append(cr.Issues, i)
+}
+
+// Reads the file, and reports it as missing if it cannot
+func (cr *ContextReport) get(fs Persistence, path string) []byte {
+	data, err := fs.Get(path)
+	if err != nil {
+		cr.issue(IssueMissing, path, nil, err)
+		return nil
+	}
+	cr.Files[path] = len(data)
+	return data
+}
+
+func (cr *ContextReport) verify(fs Persistence, ctx *Context) {
+
+	db := &StateDB{
+		ctx:     ctx,
+		mutable: make(MutKeyTypeMap),
+	}
+
+	path := ctx.ImmPath()
+	if data := cr.get(fs, path); data != nil {
+		var err error
+		if ctx.Dedup {
+			db.immutable, err = cr.manifest(fs, data)
+		} else {
+			db.immutable, err = decodeImmutable(data)
+		}
+		if err != nil {
+			cr.issue(IssueCorrupt, path, nil, err)
+		}
+	}
+
+	if ctx.MCNT > 0 {
+		path := ctx.MutPath()
+		if data := cr.get(fs, path); data != nil {
+			mut, err := decodeMutable(data)
+			if err != nil {
+				cr.issue(IssueCorrupt, path, nil, err)
+			} else if mut != nil {
+				db.mutable = mut
+			}
+		}
+	}
+
+	deltas := []DeltaTypeMap{}
+	for _, path := range ctx.DeltaPaths() {
+		data := cr.get(fs, path)
+		if data == nil {
+			continue
+		}
+		delta, err := decodeDelta(data)
+		if err != nil {
+			cr.issue(IssueCorrupt, path, nil, err)
+			continue
+		}
+		deltas = append(deltas, delta)
+	}
+
+	// nothing left to check without the reference checkpoint
+	if db.immutable == nil {
+		return
+	}
+	// the deltas only replay in order, and the states
+	// are only complete once every delta is replayed
+	if len(deltas) == len(ctx.DeltaPaths()) {
+		if err := db.replay(deltas); err != nil {
+			cr.issue(IssueReplay, ctx.ImmPath(), nil, err)
+		} else {
+			for _, kt := range orphans(db.immutable, db.mutable) {
+				cr.issue(IssueOrphan, ctx.MutPath(), kt, nil)
+			}
+		}
+	}
+	cr.Immutable = db.immutable.count()
+	cr.Mutable = db.mutable.count()
+}
+
+// Decodes the manifest, and checks that every chunk exists and
+// matches its hash
+func (cr *ContextReport) manifest(fs Persistence, data []byte) (ImmKeyTypeMap, error) {
+
+	m, err := decodeManifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	imm := make(ImmKeyTypeMap, len(m.Entries))
+	seen := make(map[string]bool)
+	for typ, entries := range m.Entries {
+		states := make(ImmStateMap, len(entries))
+		for k, ref := range entries {
+			states[k] = &ImmState{KT: ref.KT, Ver: ref.Ver}
+			for _, hash := range ref.Chunks {
+				if seen[hash] {
+					continue
+				}
+				seen[hash] = true
+				path := chunkPath(hash)
+				chunk, err := fs.Get(path)
+				if err != nil {
+					cr.issue(IssueMissing, path, &ref.KT, err)
+					continue
+				}
+				// the chunks are summed up, like in a Snapshot
+				cr.Files[casDir] += len(chunk)
+				if hashChunk(chunk) != hash {
+					cr.issue(IssueCorrupt, path, &ref.KT, fmt.Errorf("chunk does not match its hash"))
+				}
+			}
+		}
+		imm[typ] = states
+	}
+	return imm, nil
+}
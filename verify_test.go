@@ -0,0 +1,105 @@
+package statedb
+
+import (
+	"testing"
+)
+
+func issueKinds(r *VerifyReport) map[string]int {
+	kinds := map[string]int{}
+	for _, c := range r.Contexts {
+		for _, i := range c.Issues {
+			kinds[i.Kind]++
+		}
+	}
+	return kinds
+}
+
+func TestVerifyHealthy(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		fs := deltaChain(t, &Options{Dedup: dedup})
+		r, err := Verify(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.OK() || len(r.Contexts) != 2 {
+			t.Fatalf("healthy checkpoint did not verify: %v", issueKinds(r))
+		}
+		for _, c := range r.Contexts {
+			if c.Latest && (c.Immutable != 2 || c.Mutable != 2) {
+				t.Fatalf("unexpected counts of latest: %d immutable, %d mutable", c.Immutable, c.Mutable)
+			}
+		}
+	}
+
+	if _, err := Verify(newMemFS()); err != NoCheckpointError {
+		t.Fatalf("expected NoCheckpointError, got %v", err)
+	}
+}
+
+func TestVerifyIssues(t *testing.T) {
+	fs := deltaChain(t, nil)
+	ctx, _ := retrieveContext(fs)
+
+	// a missing delta is only referenced by the latest context
+	fs.Delete(ctx.DeltaPaths()[1])
+	fs.Put(ctx.MutPath(), []byte("garbage"))
+	r, _ := Verify(fs)
+	kinds := issueKinds(r)
+	if r.OK() || kinds[IssueMissing] != 1 || kinds[IssueCorrupt] != 1 {
+		t.Fatalf("unexpected issues: %v", kinds)
+	}
+	for _, c := range r.Contexts {
+		if c.Latest == c.OK() {
+			t.Fatalf("only the latest context should have issues: %s %v", c.Path, c.Issues)
+		}
+	}
+}
+
+func TestVerifyUnreadableContexts(t *testing.T) {
+	fs := deltaChain(t, nil)
+
+	// both context files exist, but neither can be read
+	fs.setFailGet("cpt0.nfo", true)
+	fs.setFailGet("cpt1.nfo", true)
+	r, err := Verify(fs)
+	if err != nil {
+		t.Fatalf("unreadable contexts were reported as no checkpoint: %v", err)
+	}
+	if kinds := issueKinds(r); r.OK() || kinds[IssueMissing] != 2 {
+		t.Fatalf("unexpected issues: %v", kinds)
+	}
+}
+
+func TestVerifyReplayAndOrphans(t *testing.T) {
+	fs := deltaChain(t, nil)
+	ctx, _ := retrieveContext(fs)
+
+	kt1, _ := NewIntKeyType(1, ReflectTypeM(&ent{}))
+	kt9, _ := NewIntKeyType(9, ReflectTypeM(&ent{}))
+
+	// a mutable state without an immutable one
+	mut := make(MutKeyTypeMap)
+	mut.insert(kt9, &MutState{KT: *kt9, Val: []byte("y"), frozen: true})
+	data, _ := encodeMutable(mut, ctx)
+	fs.Put(ctx.MutPath(), data)
+
+	r, _ := Verify(fs)
+	if kinds := issueKinds(r); len(kinds) != 1 || kinds[IssueOrphan] != 1 {
+		t.Fatalf("unexpected issues: %v", kinds)
+	}
+	// a restore reports the orphan instead of panicking
+	if _, err := restore(fs, nil); err == nil {
+		t.Fatal("restored a checkpoint with an orphaned mutable state")
+	}
+
+	// a delta that re-creates a state
+	delta := make(DeltaTypeMap)
+	delta.insert(kt1, []byte("x"), 0)
+	data, _ = encodeDelta(delta, 2)
+	fs.Put(ctx.DeltaPaths()[1], data)
+
+	r, _ = Verify(fs)
+	if kinds := issueKinds(r); kinds[IssueReplay] != 1 {
+		t.Fatalf("unexpected issues: %v", kinds)
+	}
+}
@@ -0,0 +1,221 @@
+package statedb
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The write-ahead log holds every INSERT and REMOVE applied since the
+// most recent checkpoint, one record per file, so a restore can replay the
+// operations a crash would otherwise lose. Context.WALSeq is the sequence
+// number of the last operation a checkpoint includes.
+const walDir = "wal"
+
+// An operation logged to the WAL
+type walRecord struct {
+	Seq    int
+	Action int // INSERT or REMOVE
+	KT     KeyType
+	Imm    []byte
+	Mut    []byte // the mutable state when it was registered
+	Ver    int
+}
+
+func walPath(seq int) string {
+	return path.Join(walDir, strconv.Itoa(seq)+".op")
+}
+
+// Returns the sequence number of a record path, or -1
+func walSeq(name string) int {
+	base := path.Base(name)
+	if !strings.HasSuffix(base, ".op") {
+		return -1
+	}
+	seq, err := strconv.Atoi(strings.TrimSuffix(base, ".op"))
+	if err != nil {
+		return -1
+	}
+	return seq
+}
+
+// Returns the sequence numbers of the records in the WAL, sorted
+func listWAL(wal Persistence) ([]int, error) {
+	items, err := wal.List(walDir + "/*")
+	if err != nil {
+		return nil, err
+	}
+	seqs := make([]int, 0, len(items))
+	for _, item := range items {
+		if seq := walSeq(item); seq >= 0 {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+// Appends the operation to the WAL before it is applied, so Register and
+// Unregister only return once it is durable. Operations that are about
+// to be refused are not logged. Called from stateLoop.
+func (db *StateDB) logOperation(so *stateOperation) error {
+	wal := db.opts.wal()
+	if wal == nil {
+		return nil
+	}
+	if (so.action == INSERT) == db.immutable.contains(so.kt) {
+		return nil
+	}
+	// the WAL belongs to the instance that took over
+	if db.fenced != nil {
+		return db.fenced
+	}
+
+	r := &walRecord{
+		Seq:    db.walSeq + 1,
+		Action: so.action,
+		KT:     *so.kt,
+	}
+	if so.action == INSERT {
+		r.Imm, r.Ver = so.imm, so.ver
+		if so.mut != nil {
+			var err error
+			if r.Mut, err = encodeImmutableEntry(so.mut.v.Elem()); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := encode(r)
+	if err != nil {
+		return err
+	}
+	if err := wal.Put(walPath(r.Seq), data); err != nil {
+		return fmt.Errorf("StateDB.WAL: %s", err.Error())
+	}
+	db.walSeq = r.Seq
+	return nil
+}
+
+// Applies the operations logged after the restored checkpoint was encoded
+// and returns the number of applied operations. A restored mutable state
+// has to be reattached like the ones of the checkpoint.
+func (db *StateDB) replayWAL() (int, error) {
+	if db.ctx != nil {
+		db.walSeq = db.ctx.WALSeq
+	}
+	wal := db.opts.wal()
+	if wal == nil {
+		return 0, nil
+	}
+
+	seqs, err := listWAL(wal)
+	if err != nil {
+		return 0, err
+	}
+
+	// replaying would move a participant past its global checkpoint
+	if db.opts.coordinated() {
+		for _, seq := range seqs {
+			if seq > db.walSeq {
+				wal.Delete(walPath(seq))
+			}
+		}
+		return 0, nil
+	}
+
+	n := 0
+	for i, seq := range seqs {
+		if seq <= db.walSeq {
+			continue
+		}
+		r, err := readRecord(wal, seq)
+		if err != nil {
+			// the write of the last record may have been torn by the
+			// crash, in which case its operation never returned
+			if i == len(seqs)-1 {
+				fmt.Printf("StateDB.WAL: discarding the incomplete %s: %s\n", walPath(seq), err.Error())
+				wal.Delete(walPath(seq))
+				break
+			}
+			return n, err
+		}
+		if err := db.opts.migrations().migrateRecord(r); err != nil {
+			return n, err
+		}
+
+		switch r.Action {
+		case INSERT:
+			var mut *MutState
+			if r.Mut != nil {
+				mut = &MutState{KT: r.KT, Val: r.Mut, Ver: r.Ver}
+				db.restored = true
+			}
+			err = db.insert(&r.KT, r.Imm, r.Ver, mut)
+		case REMOVE:
+			err = db.remove(&r.KT)
+		default:
+			err = UnknownOperation
+		}
+		if err != nil {
+			return n, fmt.Errorf("StateDB.WAL: %s: %s", walPath(seq), err.Error())
+		}
+		db.walSeq = seq
+		n++
+	}
+	return n, nil
+}
+
+// Continues the sequence after the records of a WAL that is not replayed,
+// as the restore failed, so they are neither overwritten by the new
+// operations nor replayed over them once a checkpoint has truncated them
+func (db *StateDB) skipWAL() error {
+	wal := db.opts.wal()
+	if wal == nil {
+		return nil
+	}
+	seqs, err := listWAL(wal)
+	if err != nil {
+		return err
+	}
+	if n := len(seqs); n > 0 && seqs[n-1] > db.walSeq {
+		fmt.Printf("StateDB.WAL: skipping %d records of the checkpoint that was not restored\n", n)
+		db.walSeq = seqs[n-1]
+	}
+	return nil
+}
+
+func readRecord(wal Persistence, seq int) (*walRecord, error) {
+	data, err := wal.Get(walPath(seq))
+	if err != nil {
+		return nil, err
+	}
+	r := &walRecord{}
+	if err := Decode(data, r); err != nil {
+		return nil, err
+	}
+	if r.Seq != seq {
+		return nil, fmt.Errorf("StateDB.WAL: %s holds the operation %d", walPath(seq), r.Seq)
+	}
+	return r, nil
+}
+
+// Deletes the records included in a committed checkpoint
+func truncateWAL(wal Persistence, upto int) {
+	seqs, err := listWAL(wal)
+	if err != nil {
+		fmt.Println("StateDB.WAL:", err)
+		return
+	}
+	for _, seq := range seqs {
+		if seq > upto {
+			break
+		}
+		if err := wal.Delete(walPath(seq)); err != nil {
+			fmt.Println("StateDB.WAL:", err)
+			return
+		}
+	}
+}
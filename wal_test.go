@@ -0,0 +1,144 @@
+package statedb
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Waits for the asynchronous truncation of the WAL
+func walRecords(t *testing.T, wal *memFS, exp int) {
+	for i := 0; ; i++ {
+		seqs, _ := listWAL(wal)
+		if len(seqs) == exp {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("expected %d records in the WAL, found %v", exp, seqs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func restoredPos(t *testing.T, db *StateDB) map[int]int {
+	it, err := Restore[ent](db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := map[int]int{}
+	for it.Next() {
+		pos[it.Value().ID] = it.Value().m.Pos
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+	return pos
+}
+
+func TestWAL(t *testing.T) {
+	fs, wal := newMemFS(), newMemFS()
+	opts := &Options{WAL: wal}
+
+	db, _ := openTestDB(t, fs, opts)
+	e2 := &ent{ID: 2}
+	registerEnts(t, db, &ent{ID: 1, m: entMut{Pos: 1}}, e2)
+	walRecords(t, wal, 2)
+	commitBlock(t, db, ZEROCPT)
+	walRecords(t, wal, 0)
+
+	// lost on a crash without the WAL
+	registerEnts(t, db, &ent{ID: 3, m: entMut{Pos: 3}})
+	kt2, _ := ReflectKeyTypeM(e2)
+	if err := db.Unregister(kt2); err != nil {
+		t.Fatal(err)
+	}
+	// refused operations are not logged
+	if err := db.Unregister(kt2); err == nil {
+		t.Fatal("unregistered a state twice")
+	}
+	walRecords(t, wal, 2)
+	db.Quit()
+
+	// a torn write of an operation that never returned
+	wal.Put(walPath(5), []byte("torn"))
+
+	db, restored := openTestDB(t, fs, opts)
+	if !restored {
+		t.Fatal("database was not restored")
+	}
+	if pos := restoredPos(t, db); len(pos) != 2 || pos[1] != 1 || pos[3] != 3 {
+		t.Fatalf("unexpected states after replaying the WAL: %v", pos)
+	}
+	commitBlock(t, db, DELTACPT)
+	walRecords(t, wal, 0)
+	if ctx, _ := retrieveContext(fs); ctx.WALSeq != 4 {
+		t.Fatalf("the checkpoint does not include the WAL: %#v", ctx)
+	}
+	db.Quit()
+
+	// nothing is replayed twice
+	db, _ = openTestDB(t, fs, opts)
+	defer db.Quit()
+	if pos := restoredPos(t, db); len(pos) != 2 {
+		t.Fatalf("unexpected states: %v", pos)
+	}
+}
+
+func TestWALWithoutCheckpoint(t *testing.T) {
+	fs := newMemFS()
+	opts := &Options{WAL: fs}
+
+	db, _ := openTestDB(t, fs, opts)
+	registerEnts(t, db, &ent{ID: 1, m: entMut{Pos: 7}})
+	db.Quit()
+
+	for name := range fs.files {
+		if !strings.HasPrefix(name, walDir+"/") {
+			t.Fatalf("unexpected file %s", name)
+		}
+	}
+
+	db, restored := openTestDB(t, fs, opts)
+	defer db.Quit()
+	if !restored {
+		t.Fatal("the WAL was not replayed")
+	}
+	if pos := restoredPos(t, db); len(pos) != 1 || pos[1] != 7 {
+		t.Fatalf("unexpected states after replaying the WAL: %v", pos)
+	}
+}
+
+func TestWALAfterFailedRestore(t *testing.T) {
+	fs, wal := newMemFS(), newMemFS()
+	opts := &Options{WAL: wal}
+
+	db, _ := openTestDB(t, fs, opts)
+	registerEnts(t, db, &ent{ID: 1})
+	commitBlock(t, db, ZEROCPT)
+	registerEnts(t, db, &ent{ID: 2}, &ent{ID: 3})
+	db.Quit()
+	walRecords(t, wal, 2)
+
+	// the restore fails, and the database starts over
+	ctx, _ := retrieveContext(fs)
+	fs.setFailGet(ctx.MutPath(), true)
+	db, restored := openTestDB(t, fs, opts)
+	if restored {
+		t.Fatal("restored a checkpoint that could not be read")
+	}
+	registerEnts(t, db, &ent{ID: 10, m: entMut{Pos: 10}})
+	if seqs, _ := listWAL(wal); len(seqs) != 3 || seqs[2] != 4 {
+		t.Fatalf("the old records were overwritten: %v", seqs)
+	}
+	commitBlock(t, db, ZEROCPT)
+	walRecords(t, wal, 0)
+	db.Quit()
+	fs.setFailGet(ctx.MutPath(), false)
+
+	// the old records are not replayed over the new checkpoint
+	db, _ = openTestDB(t, fs, opts)
+	defer db.Quit()
+	if pos := restoredPos(t, db); len(pos) != 1 || pos[10] != 10 {
+		t.Fatalf("unexpected restored states: %v", pos)
+	}
+}